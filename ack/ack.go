@@ -0,0 +1,223 @@
+// Package ack reminds of the hosts staying down, until their outage is
+// acknowledged by someone working on it.
+//
+// The notifiers get the reminders with the stage of NewStage, e.g.
+//
+//	pool.Notify = pingd.Pipe(notifier, ack.NewStage(time.Hour))
+//
+// which sends a REMINDER event every hour for each host down and not
+// acknowledged. Outages are acknowledged with Acknowledge, or with the
+// HTTP receivers under Prefix:
+//
+//	GET  /ack/  list the outages and their acknowledgement, as JSON
+//	POST /ack/  acknowledge the outage of a host, e.g. {"host": "db1", "author": "ops", "comment": "disk replaced"}
+//
+// An acknowledgement lasts until the host goes up. Outages are kept in
+// memory, and in the Store if set, so that they and their acknowledgements
+// outlive restarts, as the hosts loaded down aren't notified DOWN again.
+package ack
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// Tick is how often the stage looks for the reminders to send
+var Tick = 30 * time.Second
+
+// Ack is the acknowledgement of an outage
+type Ack struct {
+	Author  string    `json:"author"`
+	Comment string    `json:"comment,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Outage is a host down, since its DOWN event
+type Outage struct {
+	Host   string    `json:"host"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+	Ack    *Ack      `json:"ack,omitempty"`
+
+	event    pingd.HostStatus // last event of the host
+	reminded time.Time        // last notification of the outage
+}
+
+// Store persists the outages and their acknowledgements
+type Store interface {
+	Load() ([]Outage, error)
+	Save([]Outage) error
+}
+
+var outages = struct {
+	sync.Mutex
+	m     map[string]*Outage
+	store Store
+}{m: make(map[string]*Outage)}
+
+// SetStore loads the outages from s, and saves them there on each change
+func SetStore(s Store) error {
+	loaded, err := s.Load()
+	if err != nil {
+		return err
+	}
+
+	outages.Lock()
+	defer outages.Unlock()
+	now := time.Now()
+	for i := range loaded {
+		o := &loaded[i]
+		o.event = pingd.HostStatus{Host: o.Host, Down: true, Event: pingd.EventDown}
+		if o.Reason != "" {
+			o.event.Reason = errors.New(o.Reason)
+		}
+		o.reminded = now
+		outages.m[o.Host] = o
+	}
+	outages.store = s
+	return nil
+}
+
+// save saves the outages in the store, if any, outages.Mutex must be held
+func save() {
+	if outages.store == nil {
+		return
+	}
+	list := make([]Outage, 0, len(outages.m))
+	for _, o := range outages.m {
+		list = append(list, *o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+	if err := outages.store.Save(list); err != nil {
+		log.Println("ERROR saving outages:", err)
+	}
+}
+
+// record starts and ends the outages of the hosts from their events,
+// the hosts UNREACHABLE being out only if still down once reachable
+func record(h pingd.HostStatus) {
+	if h.Event == pingd.EventUnreachable {
+		return
+	}
+
+	outages.Lock()
+	defer outages.Unlock()
+
+	now := time.Now()
+	o, ok := outages.m[h.Host]
+	if !h.Down {
+		if ok {
+			delete(outages.m, h.Host)
+			save()
+		}
+		return
+	}
+	if !ok {
+		o = &Outage{Host: h.Host, Since: now.Add(-h.Duration)}
+		if h.Reason != nil {
+			o.Reason = h.Reason.Error()
+		}
+		outages.m[h.Host] = o
+		save()
+	}
+	o.event = h
+	o.reminded = now
+}
+
+// Acknowledge acknowledges the outage of a host, stopping
+// its reminders until it goes up
+func Acknowledge(host, author, comment string) error {
+	if author == "" {
+		return errors.New("missing author")
+	}
+
+	outages.Lock()
+	defer outages.Unlock()
+
+	o, ok := outages.m[host]
+	if !ok {
+		return fmt.Errorf("host %s is not down", host)
+	}
+	o.Ack = &Ack{Author: author, Comment: comment, At: time.Now()}
+	save()
+	log.Printf("ACK %s by %s", host, author)
+	return nil
+}
+
+// Acked returns the acknowledgement of the outage of a host, if any
+func Acked(host string) (Ack, bool) {
+	outages.Lock()
+	defer outages.Unlock()
+
+	if o, ok := outages.m[host]; ok && o.Ack != nil {
+		return *o.Ack, true
+	}
+	return Ack{}, false
+}
+
+// Outages returns the hosts down
+func Outages() map[string]Outage {
+	outages.Lock()
+	defer outages.Unlock()
+
+	list := make(map[string]Outage, len(outages.m))
+	for host, o := range outages.m {
+		list[host] = *o
+	}
+	return list
+}
+
+// reminders returns the reminders to send of the outages
+// not acknowledged nor notified for every
+func reminders(every time.Duration) []pingd.HostStatus {
+	outages.Lock()
+	defer outages.Unlock()
+
+	now := time.Now()
+	var events []pingd.HostStatus
+	for _, o := range outages.m {
+		if o.Ack != nil || now.Sub(o.reminded) < every {
+			continue
+		}
+		o.reminded = now
+
+		h := o.event
+		h.Event = pingd.EventReminder
+		h.Duration = now.Sub(o.Since)
+		h.Message = fmt.Sprintf("down for %s, not acknowledged", h.Duration.Round(time.Second))
+		events = append(events, h)
+	}
+	return events
+}
+
+// NewStage returns the stage sending a REMINDER event every
+// for each host down whose outage isn't acknowledged
+func NewStage(every time.Duration) pingd.Stage {
+	return func(in <-chan pingd.HostStatus, out chan<- pingd.HostStatus) {
+		tick := Tick
+		if every < tick {
+			tick = every
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case h := <-in:
+				record(h)
+				out <- h
+
+			case <-ticker.C:
+				for _, h := range reminders(every) {
+					out <- h
+				}
+			}
+		}
+	}
+}
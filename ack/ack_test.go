@@ -0,0 +1,156 @@
+package ack
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func TestReminders(t *testing.T) {
+	defer func(tick time.Duration) { Tick = tick }(Tick)
+	Tick = 10 * time.Millisecond
+
+	in := make(chan pingd.HostStatus)
+	out := make(chan pingd.HostStatus, 100)
+	go NewStage(30*time.Millisecond)(in, out)
+
+	next := func() pingd.HostStatus {
+		select {
+		case h := <-out:
+			return h
+		case <-time.After(time.Second):
+			t.Fatal("Missing event")
+		}
+		return pingd.HostStatus{}
+	}
+
+	in <- pingd.HostStatus{Host: "h1", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+	in <- pingd.HostStatus{Host: "h2", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+	next()
+	next()
+
+	// both are reminded until acknowledged
+	reminded := map[string]int{}
+	for i := 0; i < 4; i++ {
+		h := next()
+		if h.Event != pingd.EventReminder || !h.Down || !strings.HasPrefix(h.Message, "down for") {
+			t.Errorf("Got event %s %t %q, expected a reminder", h.Host, h.Down, h.Message)
+		}
+		reminded[h.Host]++
+	}
+	if reminded["h1"] != 2 || reminded["h2"] != 2 {
+		t.Errorf("Got reminders %v, expected 2 each", reminded)
+	}
+
+	if err := Acknowledge("h1", "ops", "on it"); err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := Acked("h1"); !ok || a.Author != "ops" {
+		t.Errorf("Incorrect ack %+v", a)
+	}
+	in <- pingd.HostStatus{Host: "h2", Down: false, Event: pingd.EventUp}
+	if h := next(); h.Host != "h2" || h.Event != pingd.EventUp {
+		t.Errorf("Got event %s %s, expected h2 UP", h.Host, h.Event)
+	}
+	select {
+	case h := <-out:
+		t.Errorf("Unexpected event %s %s", h.Host, h.Event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the ack lasts until the host is up
+	in <- pingd.HostStatus{Host: "h1", Down: false, Event: pingd.EventUp}
+	next()
+	if _, ok := Acked("h1"); ok {
+		t.Error("Ack of h1 kept after it went up")
+	}
+	if err := Acknowledge("h1", "ops", ""); err == nil {
+		t.Error("Expected error acknowledging host up")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	record(pingd.HostStatus{Host: "h3", Down: true, Event: pingd.EventDown})
+	defer record(pingd.HostStatus{Host: "h3", Down: false, Event: pingd.EventUp})
+
+	tests := []struct {
+		method, body string
+		code         int
+	}{
+		{"POST", `{"host": "h3", "author": "ops", "comment": "on it"}`, http.StatusOK},
+		{"POST", `{"host": "h4", "author": "ops"}`, http.StatusBadRequest},
+		{"POST", `{"host": "h3"}`, http.StatusBadRequest},
+		{"GET", "", http.StatusOK},
+		{"DELETE", "", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		Handler.ServeHTTP(w, httptest.NewRequest(test.method, Prefix, strings.NewReader(test.body)))
+		if w.Code != test.code {
+			t.Errorf("%s %s got %d, expected %d: %s", test.method, test.body, w.Code, test.code, w.Body)
+		}
+	}
+	if o := Outages()["h3"]; o.Ack == nil || o.Ack.Comment != "on it" {
+		t.Errorf("Incorrect outage %+v", o)
+	}
+}
+
+// memStore keeps the outages saved in memory
+type memStore struct {
+	saved []Outage
+}
+
+func (m *memStore) Load() ([]Outage, error) { return m.saved, nil }
+func (m *memStore) Save(list []Outage) error {
+	m.saved = list
+	return nil
+}
+
+// TestRestart tests the outages and their acknowledgements outlive
+// restarts, the hosts loaded down having no DOWN event
+func TestRestart(t *testing.T) {
+	reset := func() {
+		outages.Lock()
+		defer outages.Unlock()
+		outages.m, outages.store = make(map[string]*Outage), nil
+	}
+	defer reset()
+
+	store := &memStore{}
+	if err := SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+	record(pingd.HostStatus{Host: "h4", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown})
+	record(pingd.HostStatus{Host: "h5", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown})
+	if err := Acknowledge("h4", "ops", "on it"); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart
+	reset()
+	if err := SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, ok := Acked("h4"); !ok || a.Author != "ops" {
+		t.Errorf("Incorrect ack after restart %+v", a)
+	}
+	if err := Acknowledge("h5", "ops", ""); err != nil {
+		t.Errorf("Acknowledging h5 after restart failed with error: %v", err)
+	}
+	if o := Outages()["h5"]; o.Reason != "timeout" || o.event.Reason == nil {
+		t.Errorf("Incorrect outage after restart %+v", o)
+	}
+
+	// the outages end as usual
+	record(pingd.HostStatus{Host: "h4", Down: false, Event: pingd.EventUp})
+	record(pingd.HostStatus{Host: "h5", Down: false, Event: pingd.EventUp})
+	if len(store.saved) != 0 {
+		t.Errorf("Got outages %+v saved, expected none", store.saved)
+	}
+}
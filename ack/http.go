@@ -0,0 +1,50 @@
+package ack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Prefix is the path the HTTP receivers serve acknowledgements under
+const Prefix = "/ack/"
+
+// request is the JSON body acknowledging an outage
+type request struct {
+	Host    string `json:"host"`
+	Author  string `json:"author"`
+	Comment string `json:"comment"`
+}
+
+// Handler lists the outages and acknowledges them
+var Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != Prefix {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Outages())
+
+	case "POST":
+		var req request
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+		if err := Acknowledge(req.Host, req.Author, req.Comment); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintf(w, "outage of %s acknowledged\n", req.Host)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+})
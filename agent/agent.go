@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// Agent is a pingd probing the hosts assigned by a central pingd,
+// e.g. inside a private network, and sending it the results
+type Agent struct {
+	Central  string // URL of the central pingd HTTP receiver, e.g. https://pingd.example.org:7700
+	Name     string // name of the agent, as given in the agent option of the hosts
+	Token    string // bearer token, if the central pingd requires one
+	Probe    pingd.ProbeFunc
+	Interval time.Duration
+	Client   *http.Client // timing out after Interval by default
+}
+
+// Run polls the central pingd and probes the assigned hosts every Interval
+func (a *Agent) Run() {
+	log.Printf("Agent %s reporting to %s", a.Name, a.Central)
+
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		if err := a.Poll(); err != nil {
+			log.Println("ERROR", err)
+		}
+	}
+}
+
+// Poll gets the assigned hosts, probes them and sends back the results
+func (a *Agent) Poll() error {
+	hosts, err := a.hosts()
+	if err != nil {
+		return err
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	results := make([]pingd.Result, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			results[i] = pingd.Result{Host: host, Probe: a.Probe(host)}
+		}(i, host)
+	}
+	wg.Wait()
+
+	return a.send(results)
+}
+
+// hosts returns the hosts assigned to the agent
+func (a *Agent) hosts() ([]string, error) {
+	body, err := a.do("GET", "hosts", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if host := strings.TrimSpace(scanner.Text()); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+// send posts the results to the central pingd
+func (a *Agent) send(results []pingd.Result) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	_, err := a.do("POST", "results", &buf)
+	return err
+}
+
+// do makes a request to the path of the agent on the central pingd
+func (a *Agent) do(method, path string, body io.Reader) ([]byte, error) {
+	u := strings.TrimSuffix(a.Central, "/") + Prefix + url.PathEscape(a.Name) + "/" + path
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: a.Interval}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s %s", method, u, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
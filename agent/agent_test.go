@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+	_ "github.com/weaming/pingd/tcping"
+)
+
+func TestAgent(t *testing.T) {
+	defer func(lost time.Duration, token string) { Lost, Token = lost, token }(Lost, Token)
+	Lost = 200 * time.Millisecond
+	Token = "secret"
+
+	server := httptest.NewServer(Handler)
+	defer server.Close()
+
+	local := func(host string) pingd.Probe {
+		return pingd.Probe{Up: true, Message: "local"}
+	}
+	central := NewProbeFunc(local)
+
+	a := &Agent{
+		Central:  server.URL,
+		Name:     "dc1",
+		Token:    "secret",
+		Interval: time.Second,
+		Probe: func(host string) pingd.Probe {
+			if strings.HasPrefix(host, "10.0.0.2") {
+				return pingd.Probe{Err: errors.New("connection refused")}
+			}
+			return pingd.Probe{Up: true, Latency: time.Millisecond, Message: "remote"}
+		},
+	}
+
+	// the agent has no hosts until the central pingd checks them
+	if err := a.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host    string
+		up      bool
+		message string
+	}{
+		{"10.0.0.1:22", true, "local"},
+		{"10.0.0.1:22#agent=dc1", true, "waiting for agent dc1"},
+		{"10.0.0.2:22#agent=dc1", true, "waiting for agent dc1"},
+	}
+	for _, test := range tests {
+		p := central(test.host)
+		if p.Up != test.up || p.Message != test.message {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", test.host, p.Up, p.Message, p.Err)
+		}
+	}
+
+	if err := a.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests = []struct {
+		host    string
+		up      bool
+		message string
+	}{
+		{"10.0.0.1:22#agent=dc1", true, "remote"},
+		{"10.0.0.2:22#agent=dc1", false, "connection refused"},
+	}
+	for _, test := range tests {
+		p := central(test.host)
+		if p.Up != test.up || (p.Up && p.Message != test.message) || (!p.Up && p.Err.Error() != test.message) {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", test.host, p.Up, p.Message, p.Err)
+		}
+	}
+
+	// the agent goes away
+	time.Sleep(Lost + 50*time.Millisecond)
+	p := central("10.0.0.1:22#agent=dc1")
+	if p.Up || p.Err == nil || !strings.HasPrefix(p.Err.Error(), "agent dc1 lost") {
+		t.Errorf("Incorrect check of lost agent resulted: %t %q with error: %v", p.Up, p.Message, p.Err)
+	}
+
+	// without the token
+	a.Token = ""
+	if err := a.Poll(); err == nil {
+		t.Error("Expected error polling without token")
+	}
+
+	// agents aren't served without a token set
+	Token = ""
+	a.Token = "secret"
+	if err := a.Poll(); err == nil {
+		t.Error("Expected error polling without agent token set")
+	}
+}
+
+func TestConsensus(t *testing.T) {
+	defer func(lost time.Duration) { Lost = lost }(Lost)
+	Lost = time.Minute
+
+	localUp := true
+	central := NewProbeFunc(func(host string) pingd.Probe {
+		if localUp {
+			return pingd.Probe{Up: true, Latency: time.Millisecond}
+		}
+		return pingd.Probe{Err: errors.New("timeout")}
+	})
+
+	// results of the agents dc1 and dc2, dc3 being lost
+	results := func(host string, dc1, dc2 bool) {
+		for name, up := range map[string]bool{"vp1": dc1, "vp2": dc2} {
+			p := pingd.Probe{Up: up}
+			if !up {
+				p.Err = errors.New("connection refused")
+			}
+			Check(name, host)
+			record(name, []pingd.Result{{Host: host, Probe: p}})
+		}
+		agents.Lock()
+		get("vp3").seen = time.Now().Add(-time.Hour)
+		agents.Unlock()
+	}
+
+	tests := []struct {
+		host     string
+		local    bool
+		dc1, dc2 bool
+		up       bool
+		message  string
+	}{
+		{"10.0.1.1:80#vantage=local,vp1,vp2", true, true, false, true, "local up, vp1 up, vp2 down (connection refused)"},
+		{"10.0.1.2:80#vantage=local,vp1,vp2", false, true, false, false, "local down (timeout), vp1 up, vp2 down (connection refused)"},
+		{"10.0.1.3:80#vantage=local,vp1,vp2&quorum=3", false, true, false, true, "local down (timeout), vp1 up, vp2 down (connection refused)"},
+		{"10.0.1.4:80#vantage=local,vp1,vp2&quorum=1", true, true, false, false, "local up, vp1 up, vp2 down (connection refused)"},
+		{"10.0.1.5:80#vantage=vp1,vp2,vp3", true, true, true, true, "vp1 up, vp2 up, vp3 unknown (agent vp3 lost, last seen 1h0m0s ago)"},
+		{"10.0.1.6:80#vantage=vp1,vp3&quorum=2", true, false, true, true, "vp1 down (connection refused), vp3 unknown (agent vp3 lost, last seen 1h0m0s ago), only 1 of 2 vantage points reporting, quorum is 2, kept UP"},
+		{"10.0.1.7:80#vantage=local,vp1&quorum=3", true, true, true, false, "invalid quorum"},
+	}
+
+	for _, test := range tests {
+		localUp = test.local
+		results(test.host, test.dc1, test.dc2)
+		p := central(test.host)
+		message := p.Message
+		if p.Err != nil && message == "" {
+			message = p.Err.Error()
+		}
+		if p.Up != test.up || !strings.HasPrefix(message, test.message) {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", test.host, p.Up, p.Message, p.Err)
+		}
+	}
+
+	if s := Status()["10.0.1.2:80#vantage=local,vp1,vp2"]; !s.Down || s.Vantages["vp1"].Up != true || s.Vantages[Local].Message != "timeout" {
+		t.Errorf("Incorrect status: %+v", s)
+	}
+
+	// a host down stays down when its vantage points are lost
+	host := "10.0.1.8:80#vantage=vp1,vp2&quorum=2"
+	results(host, false, false)
+	if p := central(host); p.Up {
+		t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", host, p.Up, p.Message, p.Err)
+	}
+	results(host, true, false)
+	agents.Lock()
+	get("vp2").seen = time.Now().Add(-time.Hour)
+	agents.Unlock()
+	if p := central(host); p.Up || !p.Unknown || p.Err == nil || !strings.HasSuffix(p.Err.Error(), "kept DOWN") {
+		t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", host, p.Up, p.Message, p.Err)
+	}
+
+	// the lost agents are down themselves
+	probe := pingd.NewProbeFunc(time.Second)
+	if p := probe("agent://vp1"); !p.Up {
+		t.Errorf("Incorrect check for host: agent://vp1 resulted: %t with error: %v", p.Up, p.Err)
+	}
+	if p := probe("agent://vp2"); p.Up || p.Err == nil || !strings.HasPrefix(p.Err.Error(), "agent vp2 lost") {
+		t.Errorf("Incorrect check for host: agent://vp2 resulted: %t with error: %v", p.Up, p.Err)
+	}
+}
@@ -0,0 +1,299 @@
+// Package agent runs probes on remote pingd agents, e.g. in private networks
+// the central pingd can't reach, with the central pool monitoring the hosts
+// from the results the agents send back.
+//
+// On the central pingd, the hosts with the agent option are checked by the
+// agent of that name, e.g. https://db1.dc1.internal/health#agent=dc1, with
+//
+//	pool.Probe = agent.NewProbeFunc(pingd.NewProbeFunc(timeout))
+//
+// and the HTTP receivers serving the agents under Prefix:
+//
+//	GET  /agent/<name>/hosts    hosts assigned to the agent, one per line
+//	POST /agent/<name>/results  results of the agent, one JSON object per line, see pingd.ParseResult
+//
+// The hosts of an agent which doesn't poll for Lost go down, as the agent
+// is lost. On the agents, Agent polls the central pingd and probes the hosts.
+//
+// Hosts can be checked from several vantage points, local being the central
+// pingd itself, and go down only when enough of them agree, e.g.
+//
+//	https://example.org#vantage=local,dc1,dc2&quorum=2
+//
+// the quorum being a majority by default. Lost agents don't vote, and the
+// host keeps its state while less than quorum vantage points are left. The
+// agents can be monitored themselves, e.g. agent://dc1 is down when dc1 is
+// lost, for their outages to be notified. The state
+// from each vantage point is in the probe message and at /agent/status,
+// with the credentials of the hosts redacted.
+//
+// As the hosts are sent to the agents with their credentials, the agents
+// must authenticate with Token, the HTTP receivers don't serve them until
+// it's set.
+package agent
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// Prefix is the path the HTTP receivers serve agents under
+const Prefix = "/agent/"
+
+var (
+	// Lost is how long an agent can go without polling before
+	// its hosts are down, it must be longer than the pool interval
+	Lost = time.Minute
+
+	// Token is the bearer token the agents must authenticate with,
+	// agents aren't served while it's not set
+	Token string
+)
+
+func init() {
+	pingd.RegisterChecker("agent", checker{})
+}
+
+// checker checks agent targets, e.g. agent://dc1, which are
+// down when the agent is lost
+type checker struct{}
+
+func (checker) Validate(t *pingd.Target) error {
+	if t.URL.Host == "" {
+		return fmt.Errorf("missing agent name in %s", t.Host)
+	}
+	return nil
+}
+
+func (checker) Check(t *pingd.Target) pingd.Probe {
+	agents.Lock()
+	defer agents.Unlock()
+
+	name := t.URL.Host
+	seen := get(name).seen
+	if seen.IsZero() {
+		if time.Since(agents.start) > Lost {
+			return pingd.Probe{Err: fmt.Errorf("agent %s lost, not seen since start %s ago", name, since(agents.start))}
+		}
+		return pingd.Probe{Up: true, Unknown: true, Message: "waiting for agent " + name}
+	}
+	if time.Since(seen) > Lost {
+		return pingd.Probe{Err: fmt.Errorf("agent %s lost, last seen %s ago", name, since(seen))}
+	}
+	return pingd.Probe{Up: true, Message: fmt.Sprintf("agent %s last seen %s ago", name, since(seen))}
+}
+
+// report is the last result of a host sent by an agent
+type report struct {
+	probe pingd.Probe
+	at    time.Time
+}
+
+// remote is the state of an agent as seen by the central pingd
+type remote struct {
+	seen    time.Time            // last request of the agent
+	hosts   map[string]time.Time // hosts assigned, last time they were checked
+	reports map[string]report
+}
+
+var agents = struct {
+	sync.Mutex
+	m     map[string]*remote
+	start time.Time
+}{m: make(map[string]*remote), start: time.Now()}
+
+// get returns the state of an agent, creating it if needed, agents.Mutex must be held
+func get(name string) *remote {
+	a, ok := agents.m[name]
+	if !ok {
+		a = &remote{hosts: make(map[string]time.Time), reports: make(map[string]report)}
+		agents.m[name] = a
+	}
+	return a
+}
+
+// NewProbeFunc returns a ProbeFunc which checks the hosts with the agent
+// option with the last result of that agent, those with the vantage option
+// from all their vantage points, and the rest with next
+func NewProbeFunc(next pingd.ProbeFunc) pingd.ProbeFunc {
+	var routes sync.Map
+
+	return func(host string) pingd.Probe {
+		var r *route
+		if v, ok := routes.Load(host); ok {
+			r = v.(*route)
+		} else {
+			t, err := pingd.ParseTarget(host)
+			if err != nil {
+				return pingd.Probe{Err: err}
+			}
+			if r, err = parseRoute(t); err != nil {
+				return pingd.Probe{Err: err}
+			}
+			routes.Store(host, r)
+		}
+
+		switch {
+		case r.vantages != nil:
+			return r.consensus(host, next)
+		case r.agent != "":
+			return Check(r.agent, host)
+		default:
+			return next(host)
+		}
+	}
+}
+
+// Check assigns host to the named agent and returns its last result
+func Check(name, host string) pingd.Probe {
+	agents.Lock()
+	defer agents.Unlock()
+
+	now := time.Now()
+	a := get(name)
+	a.hosts[host] = now
+
+	seen := a.seen
+	if seen.IsZero() {
+		seen = agents.start
+	}
+	if now.Sub(seen) > Lost {
+		if a.seen.IsZero() {
+			return pingd.Probe{Unknown: true, Err: fmt.Errorf("agent %s lost, not seen since start %s ago", name, since(seen))}
+		}
+		return pingd.Probe{Unknown: true, Err: fmt.Errorf("agent %s lost, last seen %s ago", name, since(seen))}
+	}
+
+	r, ok := a.reports[host]
+	if !ok {
+		return pingd.Probe{Up: true, Unknown: true, Message: "waiting for agent " + name}
+	}
+	if now.Sub(r.at) > Lost {
+		return pingd.Probe{Unknown: true, Err: fmt.Errorf("agent %s stopped reporting, last result %s ago", name, since(r.at))}
+	}
+	return r.probe
+}
+
+func since(t time.Time) time.Duration {
+	return time.Since(t).Round(time.Second)
+}
+
+// assigned returns the hosts assigned to the named agent, dropping
+// those not checked for Lost, as they aren't monitored anymore
+func assigned(name string) []string {
+	agents.Lock()
+	defer agents.Unlock()
+
+	now := time.Now()
+	a := get(name)
+	a.seen = now
+
+	hosts := []string{}
+	for host, checked := range a.hosts {
+		if now.Sub(checked) > Lost {
+			delete(a.hosts, host)
+			delete(a.reports, host)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// record keeps the results of the named agent for its assigned hosts,
+// returning how many were taken
+func record(name string, results []pingd.Result) int {
+	agents.Lock()
+	defer agents.Unlock()
+
+	now := time.Now()
+	a := get(name)
+	a.seen = now
+
+	n := 0
+	for _, r := range results {
+		if _, ok := a.hosts[r.Host]; !ok {
+			continue
+		}
+		a.reports[r.Host] = report{probe: r.Probe, at: now}
+		n++
+	}
+	return n
+}
+
+// Handler serves the agents their hosts at /agent/<name>/hosts, takes
+// their results at /agent/<name>/results and serves the agents and
+// vantage points state at /agent/status
+var Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if Token == "" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "agents disabled, no agent token set\n")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+Token)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "invalid agent token\n")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, Prefix)
+	if path == "status" {
+		serveStatus(w, r)
+		return
+	}
+
+	i := strings.Index(path, "/")
+	if i <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "missing agent name\n")
+		return
+	}
+	name := path[:i]
+
+	switch path[i:] {
+	case "/hosts":
+		for _, host := range assigned(name) {
+			fmt.Fprintln(w, host)
+		}
+
+	case "/results":
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var results []pingd.Result
+		scanner := bufio.NewScanner(r.Body)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			result, err := pingd.ParseResult(scanner.Bytes())
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "line %d: %s\n", line, err)
+				return
+			}
+			results = append(results, result)
+		}
+		if err := scanner.Err(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintf(w, "%d results taken\n", record(name, results))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+})
@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// Local is the vantage point of the central pingd itself
+const Local = "local"
+
+// route is how a host is checked by the central pingd
+type route struct {
+	agent    string   // agent checking the host, if any
+	vantages []string // vantage points checking the host, if given
+	quorum   int      // vantage points which must agree the host is down
+}
+
+// parseRoute reads the agent, vantage and quorum options of the target
+func parseRoute(t *pingd.Target) (*route, error) {
+	r := &route{agent: t.Options.Get("agent")}
+
+	s := t.Options.Get("vantage")
+	if s == "" {
+		if t.Options.Get("quorum") != "" {
+			return nil, errors.New("quorum without vantage points")
+		}
+		return r, nil
+	}
+	if r.agent != "" {
+		return nil, errors.New("both agent and vantage points given")
+	}
+
+	seen := make(map[string]bool)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			return nil, fmt.Errorf("invalid vantage points %q", s)
+		}
+		seen[v] = true
+		r.vantages = append(r.vantages, v)
+	}
+
+	r.quorum = len(r.vantages)/2 + 1
+	if s := t.Options.Get("quorum"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > len(r.vantages) {
+			return nil, fmt.Errorf("invalid quorum %q, expected 1 to %d", s, len(r.vantages))
+		}
+		r.quorum = n
+	}
+	return r, nil
+}
+
+// consensus checks host from all the vantage points, it's down
+// only if at least quorum of them report it down
+func (r *route) consensus(host string, next pingd.ProbeFunc) pingd.Probe {
+	probes := make([]pingd.Probe, len(r.vantages))
+	for i, v := range r.vantages {
+		if v == Local {
+			probes[i] = next(host)
+		} else {
+			probes[i] = Check(v, host)
+		}
+	}
+
+	var p pingd.Probe
+	var states, reasons []string
+	down, voters := 0, 0
+	for i, v := range r.vantages {
+		vp := probes[i]
+		states = append(states, v+" "+state(vp))
+		// lost agents don't vote, those waiting for a first result count as up
+		if vp.Unknown && !vp.Up {
+			continue
+		}
+		voters++
+		if vp.Up {
+			if p.Latency == 0 {
+				p.Latency = vp.Latency
+			}
+			continue
+		}
+		down++
+		reasons = append(reasons, fmt.Sprintf("%s: %v", v, vp.Err))
+	}
+
+	p.Message = strings.Join(states, ", ")
+	switch {
+	case down >= r.quorum:
+		p.Err = fmt.Errorf("down from %d of %d vantage points, %s", down, len(r.vantages), strings.Join(reasons, ", "))
+	case voters < r.quorum:
+		// the host keeps its state until enough vantage points are back,
+		// the lost agents being down themselves, see the agent checker
+		p.Unknown = true
+		lost := fmt.Sprintf("only %d of %d vantage points reporting, quorum is %d", voters, len(r.vantages), r.quorum)
+		if vantages.down(host) {
+			p.Err = errors.New(lost + ", kept DOWN")
+		} else {
+			p.Up = true
+			p.Message += ", " + lost + ", kept UP"
+		}
+	default:
+		p.Up = true
+		p.Degraded = down > 0
+	}
+
+	vantages.record(host, r.vantages, probes, !p.Up)
+	return p
+}
+
+// state describes a probe from a vantage point
+func state(p pingd.Probe) string {
+	switch {
+	case p.Unknown && !p.Up:
+		return fmt.Sprintf("unknown (%v)", p.Err)
+	case p.Unknown:
+		return "unknown (" + p.Message + ")"
+	case p.Up:
+		return "up"
+	default:
+		return fmt.Sprintf("down (%v)", p.Err)
+	}
+}
+
+// VantageState is the last state of a host from a vantage point
+type VantageState struct {
+	Up      bool   `json:"up"`
+	Unknown bool   `json:"unknown,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// HostState is the last consensus on a host and the states it came from
+type HostState struct {
+	Down     bool                    `json:"down"`
+	Vantages map[string]VantageState `json:"vantages"`
+	Checked  time.Time               `json:"checked"`
+}
+
+var vantages = &states{m: make(map[string]HostState)}
+
+// states keeps the last state of the hosts checked from several vantage points
+type states struct {
+	sync.Mutex
+	m map[string]HostState
+}
+
+func (s *states) record(host string, names []string, probes []pingd.Probe, down bool) {
+	h := HostState{Down: down, Vantages: make(map[string]VantageState), Checked: time.Now()}
+	for i, name := range names {
+		vs := VantageState{Up: probes[i].Up, Unknown: probes[i].Unknown, Message: probes[i].Message}
+		if probes[i].Err != nil {
+			vs.Message = probes[i].Err.Error()
+		}
+		h.Vantages[name] = vs
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.m[host] = h
+}
+
+// down tells if the last consensus on host is down
+func (s *states) down(host string) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.m[host].Down
+}
+
+// Status returns the last state of the hosts checked from several
+// vantage points, dropping those not checked for Lost
+func Status() map[string]HostState {
+	vantages.Lock()
+	defer vantages.Unlock()
+
+	status := make(map[string]HostState, len(vantages.m))
+	for host, h := range vantages.m {
+		if time.Since(h.Checked) > Lost {
+			delete(vantages.m, host)
+			continue
+		}
+		status[pingd.Redact(host)] = h
+	}
+	return status
+}
+
+// lastSeen returns when each agent was last seen
+func lastSeen() map[string]time.Time {
+	agents.Lock()
+	defer agents.Unlock()
+
+	seen := make(map[string]time.Time, len(agents.m))
+	for name, a := range agents.m {
+		seen[name] = a.seen
+	}
+	return seen
+}
+
+// serveStatus serves the agents last seen time and the per vantage point
+// state of the hosts as JSON
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agents": lastSeen(),
+		"hosts":  Status(),
+	})
+}
@@ -0,0 +1,249 @@
+package pingd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Probe is the outcome of checking a host once
+type Probe struct {
+	Up       bool
+	Degraded bool          // up, but with warnings
+	Unknown  bool          // the check could not tell, the host counts as not up
+	Latency  time.Duration // time it took to get the answer
+	Message  string        // short description of the answer, e.g. a server banner
+	Err      error         // reason why the host is not up
+	Metrics  []Metric      // measurements reported by the check
+}
+
+// Metric is a measurement reported by a check, like Nagios plugins perfdata.
+// Thresholds and bounds are kept as given, empty when not.
+type Metric struct {
+	Label string  `json:"label"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+	Warn  string  `json:"warn,omitempty"`
+	Crit  string  `json:"crit,omitempty"`
+	Min   string  `json:"min,omitempty"`
+	Max   string  `json:"max,omitempty"`
+}
+
+// ProbeFunc is function signature for checks reporting a full Probe
+type ProbeFunc func(host string) Probe
+
+// Probe adapts a PingFunc to a ProbeFunc, measuring the latency of the ping
+func (f PingFunc) Probe(host string) Probe {
+	start := time.Now()
+	up, err := f(host)
+	return Probe{Up: up, Latency: time.Since(start), Err: err}
+}
+
+// Target is a host parsed for checking. Hosts are URLs whose scheme
+// selects the Checker, e.g. https://example.com/health. Check options
+// are given in the URL fragment, e.g. tcp://example.com:22#timeout=2s,
+// so they work the same for every scheme and are never sent to the target.
+// Hosts without a scheme are checked with ICMP, or TCP when they have a port.
+type Target struct {
+	Host    string        // host as given to the pool
+	URL     *url.URL      // parsed host, without the check options
+	Options url.Values    // check options, falling back to the scheme defaults
+	Timeout time.Duration // timeout option, zero means the pool default
+}
+
+// Checker checks the targets of one URL scheme
+type Checker interface {
+	// Validate is called once when the target is parsed and
+	// rejects targets the checker can not check
+	Validate(t *Target) error
+	// Check probes the target once
+	Check(t *Target) Probe
+}
+
+// Watcher is implemented by the checkers keeping state about their
+// targets, told by the pool when it starts and stops monitoring them.
+// A target may be watched again while it's watched.
+type Watcher interface {
+	Watch(t *Target)
+	Unwatch(t *Target)
+}
+
+// watch tells the checker of host, if it's a Watcher, that
+// the pool starts monitoring it, or stops when start is false
+func watch(host string, start bool) {
+	t, err := ParseTarget(host)
+	if err != nil {
+		return
+	}
+	c, _, _ := lookup(strings.ToLower(t.URL.Scheme))
+	w, ok := c.(Watcher)
+	if !ok {
+		return
+	}
+	if start {
+		w.Watch(t)
+	} else {
+		w.Unwatch(t)
+	}
+}
+
+// CheckerFunc adapts a function to a Checker which accepts all targets
+type CheckerFunc func(t *Target) Probe
+
+// Validate accepts all targets
+func (f CheckerFunc) Validate(t *Target) error { return nil }
+
+// Check calls f(t)
+func (f CheckerFunc) Check(t *Target) Probe { return f(t) }
+
+// registry holds the checkers and their default options by scheme
+var registry = struct {
+	sync.RWMutex
+	checkers map[string]Checker
+	defaults map[string]url.Values
+}{
+	checkers: make(map[string]Checker),
+	defaults: make(map[string]url.Values),
+}
+
+// RegisterChecker makes a checker available for the targets with the
+// given scheme. Check packages register themselves when imported,
+// registering an existing scheme again replaces its checker.
+func RegisterChecker(scheme string, c Checker) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.checkers[strings.ToLower(scheme)] = c
+}
+
+// SetDefaultOptions sets the check options used for the targets with the
+// given scheme which don't set them in their URL fragment
+func SetDefaultOptions(scheme string, options url.Values) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.defaults[strings.ToLower(scheme)] = options
+}
+
+// Schemes returns the sorted list of registered schemes
+func Schemes() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	schemes := make([]string, 0, len(registry.checkers))
+	for scheme := range registry.checkers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// lookup returns the checker and a copy of the default options for the scheme
+func lookup(scheme string) (Checker, url.Values, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	c, ok := registry.checkers[scheme]
+	options := url.Values{}
+	for k, v := range registry.defaults[scheme] {
+		options[k] = append([]string(nil), v...)
+	}
+	return c, options, ok
+}
+
+// ParseTarget parses a host into a Target and validates it with the
+// checker registered for its scheme
+func ParseTarget(host string) (*Target, error) {
+	raw := host
+	if !strings.Contains(host, "://") {
+		hostname := host
+		if i := strings.IndexAny(hostname, "#/?"); i >= 0 {
+			hostname = hostname[:i]
+		}
+		if ip := net.ParseIP(hostname); ip != nil && ip.To4() == nil {
+			// bare IPv6 address
+			raw = "icmp://[" + hostname + "]" + host[len(hostname):]
+		} else if strings.Contains(hostname, ":") {
+			raw = "tcp://" + host
+		} else {
+			raw = "icmp://" + host
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" && u.Opaque == "" && u.Path == "" {
+		return nil, fmt.Errorf("missing host in %s", host)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	c, options, ok := lookup(scheme)
+	if !ok {
+		return nil, fmt.Errorf("no checker for scheme %q of %s", scheme, host)
+	}
+
+	values, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check options in %s: %s", host, err)
+	}
+	for k, v := range values {
+		options[k] = v
+	}
+	u.Fragment = ""
+
+	t := &Target{Host: host, URL: u, Options: options}
+	if s := options.Get("timeout"); s != "" {
+		if t.Timeout, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid timeout %q in %s", s, host)
+		}
+	}
+
+	if err := c.Validate(t); err != nil {
+		return nil, fmt.Errorf("invalid target %s: %s", host, err)
+	}
+	return t, nil
+}
+
+// NewProbeFunc returns a ProbeFunc which checks each host with the checker
+// registered for its scheme, using timeout unless the host sets its own.
+// Hosts are parsed and validated the first time they are checked.
+func NewProbeFunc(timeout time.Duration) ProbeFunc {
+	var targets sync.Map
+
+	return func(host string) Probe {
+		var t *Target
+		if v, ok := targets.Load(host); ok {
+			t = v.(*Target)
+		} else {
+			var err error
+			if t, err = ParseTarget(host); err != nil {
+				return Probe{Err: err}
+			}
+			if t.Timeout == 0 {
+				t.Timeout = timeout
+			}
+			targets.Store(host, t)
+		}
+
+		c, _, ok := lookup(strings.ToLower(t.URL.Scheme))
+		if !ok {
+			return Probe{Err: fmt.Errorf("no checker for scheme %q of %s", t.URL.Scheme, host)}
+		}
+		return c.Check(t)
+	}
+}
+
+// NewPingFunc is like NewProbeFunc for pools using a PingFunc
+func NewPingFunc(timeout time.Duration) PingFunc {
+	probe := NewProbeFunc(timeout)
+	return func(host string) (bool, error) {
+		p := probe(host)
+		return p.Up, p.Err
+	}
+}
@@ -0,0 +1,107 @@
+package pingd
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseTarget(t *testing.T) {
+	RegisterChecker("icmp", CheckerFunc(func(t *Target) Probe { return Probe{Up: true} }))
+	RegisterChecker("tcp", CheckerFunc(func(t *Target) Probe { return Probe{Up: true} }))
+	RegisterChecker("test", testChecker{})
+	SetDefaultOptions("test", url.Values{"mode": {"default"}, "timeout": {"2s"}})
+
+	var targettests = []struct {
+		host    string
+		scheme  string
+		address string
+		mode    string
+		timeout time.Duration
+		err     bool
+	}{
+		{"8.8.8.8", "icmp", "8.8.8.8", "", 0, false},
+		{"example.com", "icmp", "example.com", "", 0, false},
+		{"::1", "icmp", "::1", "", 0, false},
+		{"example.com:22", "tcp", "example.com", "", 0, false},
+		{"test://example.com", "test", "example.com", "default", 2 * time.Second, false},
+		{"test://example.com#mode=custom&timeout=1s", "test", "example.com", "custom", time.Second, false},
+		{"test://example.com#mode=invalid", "", "", "", 0, true},
+		{"test://example.com#timeout=soon", "", "", "", 0, true},
+		{"unknown://example.com", "", "", "", 0, true},
+	}
+
+	for _, tt := range targettests {
+		target, err := ParseTarget(tt.host)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Expected error parsing %s", tt.host)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %s", tt.host, err)
+			continue
+		}
+		if target.URL.Scheme != tt.scheme || target.URL.Hostname() != tt.address ||
+			target.Options.Get("mode") != tt.mode || target.Timeout != tt.timeout {
+			t.Errorf("Incorrect target for %s: %s %s %v %s", tt.host, target.URL.Scheme, target.URL.Hostname(), target.Options, target.Timeout)
+		}
+		if target.URL.Fragment != "" {
+			t.Errorf("Check options left in target URL %s", target.URL)
+		}
+	}
+}
+
+func TestProbeFunc(t *testing.T) {
+	RegisterChecker("test", testChecker{})
+	SetDefaultOptions("test", nil)
+	probe := NewProbeFunc(3 * time.Second)
+
+	if p := probe("test://example.com"); !p.Up || p.Message != "3s" {
+		t.Errorf("Incorrect probe: %+v", p)
+	}
+	if p := probe("test://example.com#timeout=1s"); !p.Up || p.Message != "1s" {
+		t.Errorf("Incorrect probe: %+v", p)
+	}
+	if p := probe("test://example.com#mode=invalid"); p.Up || p.Err == nil {
+		t.Errorf("Invalid target probed: %+v", p)
+	}
+}
+
+// testChecker reports the target timeout in the probe message
+type testChecker struct{}
+
+func (testChecker) Validate(t *Target) error {
+	if t.Options.Get("mode") == "invalid" {
+		return errors.New("invalid mode")
+	}
+	return nil
+}
+
+func (testChecker) Check(t *Target) Probe {
+	return Probe{Up: true, Message: t.Timeout.String()}
+}
+
+// watchChecker records the targets watched
+type watchChecker struct {
+	CheckerFunc
+	watched map[string]bool
+}
+
+func (c watchChecker) Watch(t *Target)   { c.watched[t.Host] = true }
+func (c watchChecker) Unwatch(t *Target) { delete(c.watched, t.Host) }
+
+func TestWatch(t *testing.T) {
+	c := watchChecker{watched: make(map[string]bool)}
+	RegisterChecker("watchtest", c)
+
+	watch("watchtest://job#interval=1h", true)
+	watch("watchtest://other", true)
+	watch("watchtest://other", false)
+	watch("nochecker://job", true)
+	if len(c.watched) != 1 || !c.watched["watchtest://job#interval=1h"] {
+		t.Errorf("Incorrect targets watched %v", c.watched)
+	}
+}
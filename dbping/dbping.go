@@ -0,0 +1,16 @@
+// Package dbping checks databases are serving, not only accepting connections.
+//
+// Importing it registers the redis, rediss and postgres schemes,
+// see the redis and postgres checkers for their targets and options.
+package dbping
+
+import (
+	"github.com/weaming/pingd"
+)
+
+func init() {
+	pingd.RegisterChecker("redis", redisChecker{})
+	pingd.RegisterChecker("rediss", redisChecker{})
+	pingd.RegisterChecker("postgres", postgresChecker{})
+	pingd.RegisterChecker("postgresql", postgresChecker{})
+}
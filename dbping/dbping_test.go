@@ -0,0 +1,229 @@
+package dbping
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// fakeRedis answers PING, AUTH, ROLE and INFO as a replica of the given role and info
+func fakeRedis(t *testing.T, password string, role string, info string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				authenticated := password == ""
+				for {
+					// *<n>\r\n then n times $<len>\r\n<arg>\r\n
+					var n int
+					if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+						return
+					}
+					args := make([]string, n)
+					for i := range args {
+						var l int
+						fmt.Fscanf(r, "$%d\r\n", &l)
+						arg := make([]byte, l+2)
+						r.Read(arg)
+						args[i] = string(arg[:l])
+					}
+
+					switch cmd := strings.ToUpper(args[0]); {
+					case cmd == "AUTH":
+						if args[1] == password {
+							authenticated = true
+							fmt.Fprint(conn, "+OK\r\n")
+						} else {
+							fmt.Fprint(conn, "-ERR invalid password\r\n")
+						}
+					case !authenticated:
+						fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+					case cmd == "PING":
+						fmt.Fprint(conn, "+PONG\r\n")
+					case cmd == "ROLE":
+						fmt.Fprintf(conn, "*1\r\n$%d\r\n%s\r\n", len(role), role)
+					case cmd == "INFO":
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(info), info)
+					}
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestRedis(t *testing.T) {
+	master := fakeRedis(t, "secret", "master", "# Replication\r\nrole:master\r\nconnected_slaves:2\r\n"+
+		"slave0:ip=10.0.0.2,port=6379,state=online,offset=100,lag=0\r\nslave1:ip=10.0.0.3,port=6379,state=online,offset=90,lag=7\r\n")
+	defer master.Close()
+	replica := fakeRedis(t, "", "slave", "# Replication\r\nrole:slave\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:2\r\n")
+	defer replica.Close()
+	broken := fakeRedis(t, "", "slave", "# Replication\r\nrole:slave\r\nmaster_link_status:down\r\n")
+	defer broken.Close()
+
+	var checktests = []struct {
+		host    string
+		up      bool
+		message string
+	}{
+		{"redis://:secret@" + master.Addr().String(), true, "PONG"},
+		{"redis://:wrong@" + master.Addr().String(), false, ""},
+		{"redis://" + master.Addr().String(), false, ""},
+		{"redis://:secret@" + master.Addr().String() + "#role=master", true, "role master"},
+		{"redis://:secret@" + master.Addr().String() + "#role=replica", false, "role master"},
+		{"redis://:secret@" + master.Addr().String() + "#max_lag=10s", true, "role master, lag 7s"},
+		{"redis://:secret@" + master.Addr().String() + "#max_lag=5s", false, "role master, lag 7s"},
+		{"redis://" + replica.Addr().String() + "#role=replica&max_lag=5s", true, "role slave, lag 2s"},
+		{"redis://" + replica.Addr().String() + "#max_lag=1s", false, "role slave, lag 2s"},
+		{"redis://" + broken.Addr().String() + "#max_lag=5s", false, "role slave"},
+	}
+
+	probe := pingd.NewProbeFunc(time.Second)
+	for _, tt := range checktests {
+		p := probe(tt.host)
+		if p.Up != tt.up || p.Message != tt.message {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", tt.host, p.Up, p.Message, p.Err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, host := range []string{"redis://localhost#role=leader", "redis://localhost#max_lag=far", "postgres://%zz@localhost/db"} {
+		if _, err := pingd.ParseTarget(host); err == nil {
+			t.Errorf("Expected error for host %s", host)
+		}
+	}
+}
+
+// fakePostgres answers the startup with a cleartext password request,
+// then the simple queries with one row, or an error for those on missing
+func fakePostgres(t *testing.T, password string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// message of type typ, with its length
+	msg := func(typ byte, parts ...[]byte) []byte {
+		body := bytes.Join(parts, nil)
+		b := []byte{typ, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(len(body)+4))
+		return append(b, body...)
+	}
+	int32b := func(n int32) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(n))
+		return b
+	}
+	int16b := func(n int16) []byte {
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(n))
+		return b
+	}
+	errorResponse := func(code, message string) []byte {
+		return msg('E', []byte("SERROR\x00C"+code+"\x00M"+message+"\x00\x00"))
+	}
+	ready := msg('Z', []byte("I"))
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				read := func(typed bool) (byte, []byte, error) {
+					var typ byte
+					if typed {
+						var err error
+						if typ, err = r.ReadByte(); err != nil {
+							return 0, nil, err
+						}
+					}
+					var n int32
+					if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+						return 0, nil, err
+					}
+					body := make([]byte, n-4)
+					_, err := io.ReadFull(r, body)
+					return typ, body, err
+				}
+
+				if _, _, err := read(false); err != nil {
+					return
+				}
+				conn.Write(msg('R', int32b(3)))
+				if _, body, err := read(true); err != nil || string(bytes.TrimRight(body, "\x00")) != password {
+					conn.Write(errorResponse("28P01", "password authentication failed"))
+					return
+				}
+				conn.Write(append(msg('R', int32b(0)), ready...))
+
+				for {
+					typ, body, err := read(true)
+					if err != nil || typ == 'X' {
+						return
+					}
+					if typ != 'Q' {
+						continue
+					}
+					if bytes.Contains(body, []byte("missing")) {
+						conn.Write(append(errorResponse("42P01", `relation "missing" does not exist`), ready...))
+						continue
+					}
+					var reply []byte
+					reply = append(reply, msg('T', int16b(1), []byte("?column?\x00"), int32b(0), int16b(0), int32b(23), int16b(4), int32b(-1), int16b(0))...)
+					reply = append(reply, msg('D', int16b(1), int32b(1), []byte("1"))...)
+					reply = append(reply, msg('C', []byte("SELECT 1\x00"))...)
+					conn.Write(append(reply, ready...))
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestPostgres(t *testing.T) {
+	ln := fakePostgres(t, "secret")
+	defer ln.Close()
+	server := "postgres://pingd:secret@" + ln.Addr().String() + "/app?sslmode=disable"
+
+	var checktests = []struct {
+		host    string
+		up      bool
+		message string
+		err     string
+	}{
+		{server, true, "SELECT 1", ""},
+		{server + "#noquery", true, "connected", ""},
+		{server + "#query=SELECT count(*) FROM jobs", true, "SELECT count(*) FROM jobs", ""},
+		{server + "#query=SELECT * FROM missing", false, "", `relation "missing" does not exist`},
+		{"postgres://pingd:wrong@" + ln.Addr().String() + "/app?sslmode=disable", false, "", "password authentication failed"},
+	}
+
+	probe := pingd.NewProbeFunc(time.Second)
+	for _, tt := range checktests {
+		p := probe(tt.host)
+		if p.Up != tt.up || p.Message != tt.message || (p.Err == nil) != (tt.err == "") || (p.Err != nil && !strings.Contains(p.Err.Error(), tt.err)) {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", tt.host, p.Up, p.Message, p.Err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package dbping
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/weaming/pingd"
+)
+
+// postgresChecker connects to postgres://[user[:password]@]host[:port][/db][?sslmode=disable]
+// targets, completing the startup handshake and authentication, and runs
+// SELECT 1. The URL query takes the lib/pq connection parameters. Check options,
+// in the URL fragment:
+//
+//	query=SELECT ...   query to run instead, it must not fail
+//	noquery            only connect and authenticate
+type postgresChecker struct{}
+
+func (postgresChecker) Validate(t *pingd.Target) error {
+	_, err := pq.ParseURL(t.URL.String())
+	return err
+}
+
+func (postgresChecker) Check(t *pingd.Target) pingd.Probe {
+	dsn, err := pq.ParseURL(t.URL.String())
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+	if !strings.Contains(dsn, "connect_timeout=") {
+		// lib/pq takes whole seconds
+		seconds := int((t.Timeout + time.Second - 1) / time.Second)
+		dsn += " connect_timeout=" + strconv.Itoa(seconds)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return pingd.Probe{Latency: time.Since(start), Err: err}
+	}
+	defer conn.Close()
+	latency := time.Since(start)
+
+	if t.Flag("noquery") {
+		return pingd.Probe{Up: true, Latency: latency, Message: "connected"}
+	}
+
+	query := t.Options.Get("query")
+	if query == "" {
+		query = "SELECT 1"
+	}
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return pingd.Probe{Latency: time.Since(start), Err: err}
+	}
+	rows.Close()
+
+	return pingd.Probe{Up: true, Latency: time.Since(start), Message: query}
+}
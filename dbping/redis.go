@@ -0,0 +1,163 @@
+package dbping
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/weaming/pingd"
+)
+
+// redisChecker sends PING to redis://[:password@]host[:port][/db] targets,
+// rediss:// using TLS. Check options, in the URL fragment:
+//
+//	role=master       role the server must have, master or slave (replica)
+//	max_lag=10s       on a master, the most a connected replica may lag behind,
+//	                  on a replica, the longest since the master was last heard
+//
+// plus the TLS options of pingd.Target.TLSConfig for rediss.
+type redisChecker struct{}
+
+func (redisChecker) Validate(t *pingd.Target) error {
+	switch role := t.Options.Get("role"); role {
+	case "", "master", "slave", "replica":
+	default:
+		return fmt.Errorf("invalid role %q, expected master or replica", role)
+	}
+	if s := t.Options.Get("max_lag"); s != "" {
+		if _, err := time.ParseDuration(s); err != nil {
+			return fmt.Errorf("invalid max_lag %q", s)
+		}
+	}
+	if t.URL.Scheme == "rediss" {
+		if _, err := t.TLSConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (redisChecker) Check(t *pingd.Target) pingd.Probe {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(t.Timeout),
+		redis.DialReadTimeout(t.Timeout),
+		redis.DialWriteTimeout(t.Timeout),
+	}
+	if t.URL.Scheme == "rediss" {
+		config, err := t.TLSConfig()
+		if err != nil {
+			return pingd.Probe{Err: err}
+		}
+		options = append(options, redis.DialTLSConfig(config))
+	}
+
+	start := time.Now()
+	conn, err := redis.DialURL(t.URL.String(), options...)
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+	defer conn.Close()
+
+	pong, err := redis.String(conn.Do("PING"))
+	latency := time.Since(start)
+	if err != nil {
+		return pingd.Probe{Latency: latency, Err: err}
+	}
+
+	p := pingd.Probe{Latency: latency, Message: pong}
+	expected := t.Options.Get("role")
+	maxLag := t.Options.Get("max_lag")
+	if expected == "" && maxLag == "" {
+		p.Up = true
+		return p
+	}
+
+	role, err := redisRole(conn)
+	if err != nil {
+		p.Err = err
+		return p
+	}
+	p.Message = "role " + role
+	if expected == "replica" {
+		expected = "slave"
+	}
+	if expected != "" && role != expected {
+		p.Err = fmt.Errorf("role is %s, expected %s", role, expected)
+		return p
+	}
+
+	if maxLag != "" {
+		max, _ := time.ParseDuration(maxLag)
+		lag, err := redisLag(conn, role)
+		if err != nil {
+			p.Err = err
+			return p
+		}
+		p.Message += fmt.Sprintf(", lag %s", lag)
+		if lag > max {
+			p.Err = fmt.Errorf("replication lag is %s, expected at most %s", lag, max)
+			return p
+		}
+	}
+
+	p.Up = true
+	return p
+}
+
+// redisRole returns the server role from the ROLE command: master, slave or sentinel
+func redisRole(conn redis.Conn) (string, error) {
+	reply, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return "", err
+	}
+	if len(reply) == 0 {
+		return "", fmt.Errorf("empty ROLE reply")
+	}
+	return redis.String(reply[0], nil)
+}
+
+// redisLag returns, on a master, the lag of the most lagging replica and,
+// on a replica, how long since the master was last heard
+func redisLag(conn redis.Conn, role string) (time.Duration, error) {
+	info, err := redis.String(conn.Do("INFO", "replication"))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := make(map[string]string)
+	var lag time.Duration
+	for _, line := range strings.Split(info, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields[kv[0]] = kv[1]
+
+		// slave0:ip=10.0.0.2,port=6379,state=online,offset=123,lag=1
+		if role == "master" && strings.HasPrefix(kv[0], "slave") {
+			for _, attr := range strings.Split(kv[1], ",") {
+				if strings.HasPrefix(attr, "lag=") {
+					seconds, _ := strconv.Atoi(strings.TrimPrefix(attr, "lag="))
+					if d := time.Duration(seconds) * time.Second; d > lag {
+						lag = d
+					}
+				}
+			}
+		}
+	}
+
+	if role == "slave" {
+		if status := fields["master_link_status"]; status != "up" {
+			return 0, fmt.Errorf("master link is %s", status)
+		}
+		seconds, err := strconv.Atoi(fields["master_last_io_seconds_ago"])
+		if err != nil {
+			return 0, fmt.Errorf("invalid master_last_io_seconds_ago %q", fields["master_last_io_seconds_ago"])
+		}
+		lag = time.Duration(seconds) * time.Second
+	}
+	return lag, nil
+}
@@ -0,0 +1,154 @@
+package pingd
+
+import (
+	"log"
+	"time"
+)
+
+// eventStopped is the event of a host no longer monitored, for the
+// router to forget it, it's never notified
+const eventStopped = "STOPPED"
+
+// router passes the events of the monitors to the notifier, holding the
+// DOWN events of the hosts UNREACHABLE as all their parents are down,
+// and notifying them UNREACHABLE instead. The DOWN events of the hosts
+// with parents wait for them to go down too, as a child can reach its
+// fail limit before its parent
+type router struct {
+	last     map[string]HostStatus // last status of each host
+	held     map[string]bool       // hosts whose DOWN event is held
+	waiting  map[string]HostStatus // DOWN events waiting for the parents
+	wait     time.Duration         // how long the DOWN events of children wait for their parents
+	recheck  time.Duration         // how long the children of a host back up have to come up
+	notifyCh chan<- HostStatus
+}
+
+// route reads the events of the monitors, and the initial status of the hosts,
+// without an event, until eventCh is closed
+func (r *router) route(eventCh <-chan HostStatus) {
+	recheckCh := make(chan string, 10)
+	waitCh := make(chan string, 10)
+	for {
+		select {
+		case e, ok := <-eventCh:
+			if !ok {
+				return
+			}
+			r.event(e, recheckCh, waitCh)
+
+		case parent := <-recheckCh:
+			r.reachable(parent)
+
+		case host := <-waitCh:
+			if e, ok := r.waiting[host]; ok {
+				delete(r.waiting, host)
+				r.down(e)
+			}
+		}
+	}
+}
+
+// event handles an event, or an initial status
+func (r *router) event(e HostStatus, recheckCh, waitCh chan<- string) {
+	if e.Event == eventStopped {
+		// the children of the host aren't unreachable through it anymore
+		delete(r.last, e.Host)
+		delete(r.held, e.Host)
+		delete(r.waiting, e.Host)
+		r.reachable(e.Host)
+		return
+	}
+	r.last[e.Host] = e
+
+	if _, ok := r.waiting[e.Host]; ok && e.Event == EventUp {
+		// back up before its DOWN event was notified
+		delete(r.waiting, e.Host)
+		return
+	}
+
+	switch {
+	case e.Event == "":
+		return
+
+	case e.Event == EventDown && !r.unreachable(e.Host) && len(Parents(e.Host)) > 0 && r.wait > 0:
+		r.waiting[e.Host] = e
+		host := e.Host
+		time.AfterFunc(r.wait, func() { waitCh <- host })
+		return
+
+	case e.Event == EventDown:
+		r.down(e)
+		return
+	}
+
+	delete(r.held, e.Host)
+	r.notifyCh <- e
+
+	if e.Event == EventUp && len(r.held) > 0 {
+		host := e.Host
+		time.AfterFunc(r.recheck, func() { recheckCh <- host })
+	}
+}
+
+// down notifies a DOWN event, as UNREACHABLE if all the parents
+// of the host are down, then the children waiting for it
+func (r *router) down(e HostStatus) {
+	if r.unreachable(e.Host) {
+		log.Println(EventUnreachable + " " + e.Host)
+		r.held[e.Host] = true
+		e.Event = EventUnreachable
+	} else {
+		delete(r.held, e.Host)
+	}
+	r.notifyCh <- e
+	r.parentDown(e.Host)
+}
+
+// parentDown notifies UNREACHABLE the DOWN events waiting for parent
+// of the children whose parents are now all down
+func (r *router) parentDown(parent string) {
+	for host, e := range r.waiting {
+		if contains(Parents(host), parent) && r.unreachable(host) {
+			delete(r.waiting, host)
+			r.down(e)
+		}
+	}
+}
+
+// reachable notifies the held DOWN events of the children of parent
+// which are still down when it's been up for a while, unless they
+// are unreachable through their other parents
+func (r *router) reachable(parent string) {
+	for host := range r.held {
+		if !contains(Parents(host), parent) || r.unreachable(host) {
+			continue
+		}
+		delete(r.held, host)
+		if e := r.last[host]; e.Down {
+			r.notifyCh <- e
+		}
+	}
+}
+
+// unreachable tells if all the parents of a host are down
+func (r *router) unreachable(host string) bool {
+	parents := Parents(host)
+	if len(parents) == 0 {
+		return false
+	}
+	for _, parent := range parents {
+		if s, ok := r.last[parent]; !ok || !s.Down {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package pingd
+
+import (
+	"errors"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestParents(t *testing.T) {
+	tests := []struct {
+		host    string
+		parents []string
+	}{
+		{"10.0.1.5", nil},
+		{"10.0.1.5#parent=10.0.0.1", []string{"10.0.0.1"}},
+		{"https://example.org/#parent=10.0.0.1&parent=10.0.0.2&status=200", []string{"10.0.0.1", "10.0.0.2"}},
+		{"https://example.org/#parent=https%3A%2F%2Fproxy.example.org%23status%3D200", []string{"https://proxy.example.org#status=200"}},
+	}
+
+	for _, test := range tests {
+		parents := Parents(test.host)
+		if len(parents) != len(test.parents) {
+			t.Errorf("Got parents %q for %s, expected %q", parents, test.host, test.parents)
+			continue
+		}
+		for i := range parents {
+			if parents[i] != test.parents[i] {
+				t.Errorf("Got parents %q for %s, expected %q", parents, test.host, test.parents)
+			}
+		}
+	}
+}
+
+// TestUnreachable tests the children of a host down are not notified
+// until it's back up, and only if they are still down
+func TestUnreachable(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	notifyChFW := make(chan HostStatus, 10)
+	resultChFW := make(chan Result)
+	var pool = &Pool{
+		Interval:      10 * time.Millisecond,
+		FailLimit:     1,
+		Notify:        NewTestNotifyFunc(notifyChFW),
+		ResultTimeout: time.Hour,
+		Ingest: func(resultCh chan<- Result) {
+			for r := range resultChFW {
+				resultCh <- r
+			}
+		},
+	}
+	go pool.Start()
+
+	down := Probe{Err: errors.New("timeout")}
+	up := Probe{Up: true}
+	for _, r := range []Result{
+		{Host: "sw", Probe: down},
+		{Host: "h1#parent=sw", Probe: down}, // unreachable
+		{Host: "h2#parent=sw", Probe: down}, // unreachable
+		{Host: "h3", Probe: down},
+		{Host: "h2#parent=sw", Probe: up}, // up before the switch
+		{Host: "sw", Probe: up},
+	} {
+		resultChFW <- r
+	}
+
+	// h1 is still down once the switch is back
+	expected := []HostStatus{
+		{Host: "sw", Down: true, Event: EventDown},
+		{Host: "h1#parent=sw", Down: true, Event: EventUnreachable},
+		{Host: "h2#parent=sw", Down: true, Event: EventUnreachable},
+		{Host: "h3", Down: true, Event: EventDown},
+		{Host: "h2#parent=sw", Down: false, Event: EventUp},
+		{Host: "sw", Down: false, Event: EventUp},
+		{Host: "h1#parent=sw", Down: true, Event: EventDown},
+	}
+	for _, e := range expected {
+		select {
+		case event := <-notifyChFW:
+			if event.Host != e.Host || event.Down != e.Down || event.Event != e.Event {
+				t.Errorf("Got event: %s %t %s, expected: %s %t %s", event.Host, event.Down, event.Event, e.Host, e.Down, e.Event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Missing event: %s %t %s", e.Host, e.Down, e.Event)
+		}
+	}
+
+	select {
+	case event := <-notifyChFW:
+		t.Errorf("Unexpected event: %s %t %s", event.Host, event.Down, event.Event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestUnreachableStop tests the children of a host down are
+// notified DOWN when it stops being monitored
+func TestUnreachableStop(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	notifyChFW := make(chan HostStatus, 10)
+	resultChFW := make(chan Result)
+	stopChFW := make(chan HostStatus)
+	var pool = &Pool{
+		Interval:      10 * time.Millisecond,
+		FailLimit:     1,
+		Notify:        NewTestNotifyFunc(notifyChFW),
+		ResultTimeout: time.Hour,
+		Receive: func(startCh, stopCh chan<- HostStatus) {
+			for h := range stopChFW {
+				stopCh <- h
+			}
+		},
+		Ingest: func(resultCh chan<- Result) {
+			for r := range resultChFW {
+				resultCh <- r
+			}
+		},
+	}
+	go pool.Start()
+
+	down := Probe{Err: errors.New("timeout")}
+	resultChFW <- Result{Host: "sw", Probe: down}
+	resultChFW <- Result{Host: "h1#parent=sw", Probe: down}
+
+	expected := []HostStatus{
+		{Host: "sw", Down: true, Event: EventDown},
+		{Host: "h1#parent=sw", Down: true, Event: EventUnreachable},
+		{Host: "h1#parent=sw", Down: true, Event: EventDown},
+	}
+	for i, e := range expected {
+		if i == 2 {
+			stopChFW <- HostStatus{Host: "sw"}
+		}
+		select {
+		case event := <-notifyChFW:
+			if event.Host != e.Host || event.Down != e.Down || event.Event != e.Event {
+				t.Errorf("Got event: %s %t %s, expected: %s %t %s", event.Host, event.Down, event.Event, e.Host, e.Down, e.Event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Missing event: %s %t %s", e.Host, e.Down, e.Event)
+		}
+	}
+}
+
+// TestUnreachableChildFirst tests the children going down before their
+// parent are notified UNREACHABLE, and DOWN if the parent stays up
+func TestUnreachableChildFirst(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	notifyChFW := make(chan HostStatus, 10)
+	resultChFW := make(chan Result)
+	var pool = &Pool{
+		Interval:      10 * time.Millisecond,
+		FailLimit:     1,
+		Notify:        NewTestNotifyFunc(notifyChFW),
+		ResultTimeout: time.Hour,
+		Ingest: func(resultCh chan<- Result) {
+			for r := range resultChFW {
+				resultCh <- r
+			}
+		},
+	}
+	go pool.Start()
+
+	down := Probe{Err: errors.New("timeout")}
+	for _, r := range []Result{
+		{Host: "h1#parent=sw", Probe: down}, // before the switch
+		{Host: "h2#parent=rt", Probe: down}, // router up
+		{Host: "h3#parent=rt", Probe: down}, // back up right away
+		{Host: "h3#parent=rt", Probe: Probe{Up: true}},
+		{Host: "sw", Probe: down},
+	} {
+		resultChFW <- r
+	}
+
+	expected := []HostStatus{
+		{Host: "sw", Down: true, Event: EventDown},
+		{Host: "h1#parent=sw", Down: true, Event: EventUnreachable},
+		{Host: "h2#parent=rt", Down: true, Event: EventDown},
+	}
+	for _, e := range expected {
+		select {
+		case event := <-notifyChFW:
+			if event.Host != e.Host || event.Down != e.Down || event.Event != e.Event {
+				t.Errorf("Got event: %s %t %s, expected: %s %t %s", event.Host, event.Down, event.Event, e.Host, e.Down, e.Event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Missing event: %s %t %s", e.Host, e.Down, e.Event)
+		}
+	}
+
+	select {
+	case event := <-notifyChFW:
+		t.Errorf("Unexpected event: %s %t %s", event.Host, event.Down, event.Event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
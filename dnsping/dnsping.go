@@ -0,0 +1,220 @@
+// Package dnsping checks DNS servers answer a query, and answer it right.
+//
+// Importing it registers the dns:// scheme, with targets in the form of
+// RFC 4501 DNS URIs: dns://resolver[:port]/name?type=MX, where the type
+// is one of A (default), AAAA, MX, TXT, CNAME, NS, SOA, PTR or SRV and an empty
+// resolver means the first one in /etc/resolv.conf. Check options, in the URL fragment:
+//
+//	proto=tcp           query over tcp instead of udp
+//	rcode=NXDOMAIN      expected response code, NOERROR by default
+//	expect=1.2.3.4      expected answer, repeated for each answer of the set, in any order,
+//	                    e.g. "10 mx.example.org." for MX. Comma separated lists are split
+//	                    too, but for TXT, whose answers may contain commas (SPF, DKIM).
+//
+// Truncated answers over udp are queried again over tcp.
+package dnsping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/weaming/pingd"
+)
+
+// ResolvConf is the file the resolver is read from when the target has none
+var ResolvConf = "/etc/resolv.conf"
+
+var types = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"MX":    dns.TypeMX,
+	"TXT":   dns.TypeTXT,
+	"CNAME": dns.TypeCNAME,
+	"NS":    dns.TypeNS,
+	"SOA":   dns.TypeSOA,
+	"PTR":   dns.TypePTR,
+	"SRV":   dns.TypeSRV,
+}
+
+func init() {
+	pingd.RegisterChecker("dns", checker{})
+}
+
+// query is the DNS query of a target and what's expected back
+type query struct {
+	server string
+	proto  string
+	name   string
+	qtype  uint16
+	rcode  int
+	expect []string
+}
+
+// parseQuery reads the query from a dns target
+func parseQuery(t *pingd.Target) (*query, error) {
+	q := &query{
+		server: t.URL.Host,
+		proto:  "udp",
+		name:   dns.Fqdn(strings.TrimPrefix(t.URL.Path, "/")),
+		qtype:  dns.TypeA,
+		rcode:  dns.RcodeSuccess,
+	}
+
+	if q.name == "." && t.URL.Path == "" {
+		return nil, errors.New("missing name to query")
+	}
+	if _, ok := dns.IsDomainName(q.name); !ok {
+		return nil, fmt.Errorf("invalid name %q", q.name)
+	}
+
+	if s := t.URL.Query().Get("type"); s != "" {
+		qtype, ok := types[strings.ToUpper(s)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported query type %q", s)
+		}
+		q.qtype = qtype
+	}
+
+	switch p := t.Options.Get("proto"); p {
+	case "", "udp":
+	case "tcp":
+		q.proto = p
+	default:
+		return nil, fmt.Errorf("invalid proto %q, expected udp or tcp", p)
+	}
+
+	if s := t.Options.Get("rcode"); s != "" {
+		rcode, ok := dns.StringToRcode[strings.ToUpper(s)]
+		if !ok {
+			return nil, fmt.Errorf("unknown rcode %q", s)
+		}
+		q.rcode = rcode
+	}
+
+	for _, s := range t.Options["expect"] {
+		answers := []string{s}
+		if q.qtype != dns.TypeTXT {
+			answers = strings.Split(s, ",")
+		}
+		for _, answer := range answers {
+			q.expect = append(q.expect, normalize(q.qtype, answer))
+		}
+	}
+	sort.Strings(q.expect)
+
+	return q, nil
+}
+
+// checker queries dns targets
+type checker struct{}
+
+func (checker) Validate(t *pingd.Target) error {
+	_, err := parseQuery(t)
+	return err
+}
+
+func (checker) Check(t *pingd.Target) pingd.Probe {
+	q, err := parseQuery(t)
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+
+	server := q.server
+	if server == "" {
+		config, err := dns.ClientConfigFromFile(ResolvConf)
+		if err != nil {
+			return pingd.Probe{Err: err}
+		}
+		if len(config.Servers) == 0 {
+			return pingd.Probe{Err: fmt.Errorf("no resolver in %s", ResolvConf)}
+		}
+		server = net.JoinHostPort(config.Servers[0], config.Port)
+	} else if t.URL.Port() == "" {
+		server = net.JoinHostPort(t.URL.Hostname(), "53")
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(q.name, q.qtype)
+	c := &dns.Client{Net: q.proto, Timeout: t.Timeout}
+	r, rtt, err := c.Exchange(m, server)
+	if err == nil && r.Truncated && q.proto == "udp" {
+		c.Net = "tcp"
+		r, rtt, err = c.Exchange(m, server)
+	}
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+
+	answers := Answers(r, q.qtype)
+	p := pingd.Probe{Latency: rtt, Message: strings.Join(answers, ", ")}
+
+	if r.Rcode != q.rcode {
+		p.Err = fmt.Errorf("response code is %s, expected %s", dns.RcodeToString[r.Rcode], dns.RcodeToString[q.rcode])
+		return p
+	}
+	if q.expect != nil && !equal(answers, q.expect) {
+		p.Err = fmt.Errorf("answer is %q, expected %q", answers, q.expect)
+		return p
+	}
+
+	p.Up = true
+	return p
+}
+
+// Answers returns the normalized data of the answers with the given type, sorted
+func Answers(r *dns.Msg, qtype uint16) []string {
+	answers := []string{}
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+
+		var data string
+		switch rr := rr.(type) {
+		case *dns.A:
+			data = rr.A.String()
+		case *dns.AAAA:
+			data = rr.AAAA.String()
+		case *dns.MX:
+			data = strconv.Itoa(int(rr.Preference)) + " " + rr.Mx
+		case *dns.TXT:
+			data = strings.Join(rr.Txt, "")
+		case *dns.CNAME:
+			data = rr.Target
+		default:
+			// the record without its header
+			data = strings.TrimPrefix(rr.String(), rr.Header().String())
+		}
+		answers = append(answers, normalize(qtype, data))
+	}
+	sort.Strings(answers)
+	return answers
+}
+
+// normalize makes answers comparable regardless of case and trailing dots,
+// but for TXT records where they are part of the text
+func normalize(qtype uint16, answer string) string {
+	answer = strings.TrimSpace(answer)
+	if qtype == dns.TypeTXT {
+		return answer
+	}
+	return strings.TrimSuffix(strings.ToLower(answer), ".")
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package dnsping
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/weaming/pingd"
+)
+
+func TestCheck(t *testing.T) {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		switch {
+		case q.Name == "example.org." && q.Qtype == dns.TypeA:
+			a1, _ := dns.NewRR("example.org. 300 IN A 192.0.2.1")
+			a2, _ := dns.NewRR("example.org. 300 IN A 192.0.2.2")
+			m.Answer = append(m.Answer, a1, a2)
+		case q.Name == "example.org." && q.Qtype == dns.TypeTXT:
+			spf, _ := dns.NewRR(`example.org. 300 IN TXT "v=spf1 include:a.example.org,b.example.org -all"`)
+			site, _ := dns.NewRR(`example.org. 300 IN TXT "site-verification=x"`)
+			m.Answer = append(m.Answer, spf, site)
+		case q.Name == "big.example.org.":
+			// too big for udp
+			if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+				m.Truncated = true
+				break
+			}
+			a, _ := dns.NewRR("big.example.org. 300 IN A 192.0.2.3")
+			m.Answer = append(m.Answer, a)
+		case q.Name == "example.org." && q.Qtype == dns.TypeMX:
+			mx, _ := dns.NewRR("example.org. 300 IN MX 10 Mail.example.org.")
+			m.Answer = append(m.Answer, mx)
+		default:
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+
+	// the same port over udp and tcp, for the truncated answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp := &dns.Server{Listener: ln, Handler: handler}
+	go tcp.ActivateAndServe()
+	defer tcp.Shutdown()
+
+	pc, err := net.ListenPacket("udp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp := &dns.Server{PacketConn: pc, Handler: handler}
+	go udp.ActivateAndServe()
+	defer udp.Shutdown()
+
+	server := "dns://" + pc.LocalAddr().String()
+	tcpServer := "dns://" + ln.Addr().String()
+
+	var checktests = []struct {
+		host    string
+		up      bool
+		message string
+	}{
+		{server + "/example.org", true, "192.0.2.1, 192.0.2.2"},
+		{server + "/example.org?type=A#expect=192.0.2.2,192.0.2.1", true, "192.0.2.1, 192.0.2.2"},
+		{server + "/example.org?type=A#expect=192.0.2.1", false, "192.0.2.1, 192.0.2.2"},
+		{server + "/example.org?type=A#expect=192.0.2.2&expect=192.0.2.1", true, "192.0.2.1, 192.0.2.2"},
+		{server + "/example.org?type=MX#expect=10 mail.example.org", true, "10 mail.example.org"},
+		{server + "/example.org?type=TXT#expect=v=spf1 include:a.example.org,b.example.org -all&expect=site-verification=x", true, "site-verification=x, v=spf1 include:a.example.org,b.example.org -all"},
+		{server + "/example.org?type=TXT#expect=v=spf1 include:a.example.org", false, "site-verification=x, v=spf1 include:a.example.org,b.example.org -all"},
+		{server + "/big.example.org", true, "192.0.2.3"},
+		{server + "/missing.example.org", false, ""},
+		{server + "/missing.example.org#rcode=NXDOMAIN", true, ""},
+		{tcpServer + "/example.org#proto=tcp", true, "192.0.2.1, 192.0.2.2"},
+	}
+
+	probe := pingd.NewProbeFunc(time.Second)
+	for _, tt := range checktests {
+		p := probe(tt.host)
+		if p.Up != tt.up || p.Message != tt.message {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", tt.host, p.Up, p.Message, p.Err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, host := range []string{"dns://127.0.0.1", "dns://127.0.0.1/example.org?type=BOGUS", "dns://127.0.0.1/example.org#proto=quic", "dns://127.0.0.1/example.org#rcode=OOPS"} {
+		if _, err := pingd.ParseTarget(host); err == nil {
+			t.Errorf("Expected error for host %s", host)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Package escalation notifies more and more people of the outages not
+// acknowledged, following an escalation policy.
+//
+// The notifiers the outages escalate to are registered by name, e.g.
+//
+//	escalation.Register("oncall", mail.NewNotifierFunc("oncall@example.org", sendMail))
+//	escalation.Register("manager", webhookNotifier)
+//	policy, err := escalation.ParsePolicy("15m:oncall,1h:oncall+manager")
+//	pool.Notify = pingd.Pipe(notifier, ack.NewStage(time.Hour), escalation.NewStage(policy))
+//
+// where a host still down and not acknowledged 15 minutes after its DOWN
+// event is notified to oncall, and after an hour to oncall and manager.
+// The notifiers an outage escalated to are notified when the host is up.
+// Escalations wait while the host is silenced, and are kept in the Store,
+// if set, to go on after a restart.
+package escalation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/ack"
+	"github.com/weaming/pingd/silence"
+)
+
+// Tick is how often the stage looks for the outages to escalate
+var Tick = 30 * time.Second
+
+// Step notifies the outages not acknowledged After their DOWN event
+type Step struct {
+	After  time.Duration
+	Notify []string // names of the registered notifiers
+}
+
+// Policy are the escalation steps, by After
+type Policy []Step
+
+// ParsePolicy parses a policy given as comma separated steps of
+// the delay and the + separated notifiers, e.g. 15m:oncall,1h:oncall+manager
+func ParsePolicy(spec string) (Policy, error) {
+	var p Policy
+	for _, s := range strings.Split(spec, ",") {
+		i := strings.Index(s, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid step %q, expected delay:notifier+notifier", s)
+		}
+		after, err := time.ParseDuration(strings.TrimSpace(s[:i]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid delay in step %q", s)
+		}
+		p = append(p, Step{After: after, Notify: strings.Split(strings.TrimSpace(s[i+1:]), "+")})
+	}
+	return p, p.Validate()
+}
+
+// Validate checks the steps are in order and their notifiers registered
+func (p Policy) Validate() error {
+	if len(p) == 0 {
+		return errors.New("no escalation steps")
+	}
+	for i, s := range p {
+		if i > 0 && s.After <= p[i-1].After {
+			return fmt.Errorf("step %d after %s is not after the previous one", i+1, s.After)
+		}
+		for _, name := range s.Notify {
+			if _, ok := notifier(name); !ok {
+				return fmt.Errorf("unknown notifier %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+var notifiers = struct {
+	sync.Mutex
+	m map[string]chan<- pingd.HostStatus
+}{m: make(map[string]chan<- pingd.HostStatus)}
+
+// Register starts a notifier outages can escalate to
+func Register(name string, n pingd.Notifier) {
+	ch := make(chan pingd.HostStatus, 10)
+	go n(ch)
+
+	notifiers.Lock()
+	defer notifiers.Unlock()
+	notifiers.m[name] = ch
+}
+
+func notifier(name string) (chan<- pingd.HostStatus, bool) {
+	notifiers.Lock()
+	defer notifiers.Unlock()
+
+	ch, ok := notifiers.m[name]
+	return ch, ok
+}
+
+// Escalation is the escalation of the outage of a host
+type Escalation struct {
+	Host     string    `json:"host"`
+	Reason   string    `json:"reason,omitempty"`
+	Since    time.Time `json:"since"`
+	Step     int       `json:"step"`               // next step
+	Notified []string  `json:"notified,omitempty"` // notifiers escalated to
+}
+
+// Store persists the escalations in progress
+type Store interface {
+	Load() ([]Escalation, error)
+	Save([]Escalation) error
+}
+
+var escalations = struct {
+	sync.Mutex
+	m     map[string]*Escalation
+	store Store
+}{m: make(map[string]*Escalation)}
+
+// SetStore loads the escalations from s, and saves them there on each change
+func SetStore(s Store) error {
+	loaded, err := s.Load()
+	if err != nil {
+		return err
+	}
+
+	escalations.Lock()
+	defer escalations.Unlock()
+	for i := range loaded {
+		escalations.m[loaded[i].Host] = &loaded[i]
+	}
+	escalations.store = s
+	return nil
+}
+
+// save saves the escalations in the store, if any, escalations.Mutex must be held
+func save() {
+	if escalations.store == nil {
+		return
+	}
+	list := make([]Escalation, 0, len(escalations.m))
+	for _, e := range escalations.m {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+	if err := escalations.store.Save(list); err != nil {
+		log.Println("ERROR saving escalations:", err)
+	}
+}
+
+// Escalations returns the escalations in progress
+func Escalations() []Escalation {
+	escalations.Lock()
+	defer escalations.Unlock()
+
+	list := make([]Escalation, 0, len(escalations.m))
+	for _, e := range escalations.m {
+		list = append(list, *e)
+	}
+	return list
+}
+
+// event starts the escalation of a host going down and ends it when
+// it's up, returning the notifiers escalated to, to send them the UP event
+func event(h pingd.HostStatus) []string {
+	escalations.Lock()
+	defer escalations.Unlock()
+
+	e, ok := escalations.m[h.Host]
+	switch {
+	case h.Down && !ok:
+		e = &Escalation{Host: h.Host, Since: time.Now().Add(-h.Duration)}
+		if h.Reason != nil {
+			e.Reason = h.Reason.Error()
+		}
+		escalations.m[h.Host] = e
+		save()
+	case !h.Down && ok:
+		delete(escalations.m, h.Host)
+		save()
+		return e.Notified
+	}
+	return nil
+}
+
+// due returns the escalations of the outages reaching their next step,
+// neither acknowledged nor silenced, and moves them to the following one
+func due(p Policy, now time.Time) []Escalation {
+	escalations.Lock()
+	defer escalations.Unlock()
+
+	var list []Escalation
+	for host, e := range escalations.m {
+		if e.Step >= len(p) || now.Sub(e.Since) < p[e.Step].After {
+			continue
+		}
+		if _, acked := ack.Acked(host); acked {
+			continue
+		}
+		if silenced, _ := silence.Silenced(host, now); silenced {
+			continue
+		}
+
+		list = append(list, *e)
+		for _, name := range p[e.Step].Notify {
+			if !contains(e.Notified, name) {
+				e.Notified = append(e.Notified, name)
+			}
+		}
+		e.Step++
+	}
+	if len(list) > 0 {
+		save()
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends an event to the named notifiers
+func send(names []string, h pingd.HostStatus) {
+	for _, name := range names {
+		if ch, ok := notifier(name); ok {
+			ch <- h
+		} else {
+			log.Printf("ERROR unknown notifier %q", name)
+		}
+	}
+}
+
+// NewStage returns the stage escalating the outages following the policy,
+// the events passing through unchanged
+func NewStage(p Policy) pingd.Stage {
+	return func(in <-chan pingd.HostStatus, out chan<- pingd.HostStatus) {
+		ticker := time.NewTicker(Tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case h := <-in:
+				if h.Event != pingd.EventReminder && h.Event != pingd.EventUnreachable {
+					if names := event(h); names != nil {
+						log.Printf("ESCALATION of %s ended, notifying %s", h.Host, strings.Join(names, ", "))
+						send(names, h)
+					}
+				}
+				out <- h
+
+			case now := <-ticker.C:
+				for _, e := range due(p, now) {
+					step := p[e.Step]
+					log.Printf("ESCALATION of %s after %s, notifying %s", e.Host, step.After, strings.Join(step.Notify, ", "))
+
+					h := pingd.HostStatus{Host: e.Host, Down: true, Duration: now.Sub(e.Since), Event: pingd.EventEscalation}
+					h.Message = fmt.Sprintf("down for %s, not acknowledged", h.Duration.Round(time.Second))
+					if e.Reason != "" {
+						h.Reason = errors.New(e.Reason)
+					}
+					send(step.Notify, h)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package escalation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/ack"
+)
+
+// register registers a notifier forwarding its events to a channel
+func register(name string) <-chan pingd.HostStatus {
+	ch := make(chan pingd.HostStatus, 100)
+	Register(name, func(in <-chan pingd.HostStatus) {
+		for h := range in {
+			ch <- h
+		}
+	})
+	return ch
+}
+
+type memStore struct {
+	list []Escalation
+}
+
+func (s *memStore) Load() ([]Escalation, error) { return s.list, nil }
+
+func (s *memStore) Save(list []Escalation) error {
+	s.list = list
+	return nil
+}
+
+func TestParsePolicy(t *testing.T) {
+	register("a")
+	register("b")
+
+	tests := []struct {
+		spec  string
+		steps int
+		valid bool
+	}{
+		{"15m:a", 1, true},
+		{"15m:a,1h:a+b", 2, true},
+		{" 15m : a , 1h : b", 2, true},
+		{"", 0, false},
+		{"15m", 0, false},
+		{"soon:a", 0, false},
+		{"1h:a,15m:b", 0, false},
+		{"15m:a,15m:b", 0, false},
+		{"15m:c", 0, false},
+	}
+	for _, test := range tests {
+		p, err := ParsePolicy(test.spec)
+		if (err == nil) != test.valid || (test.valid && len(p) != test.steps) {
+			t.Errorf("Incorrect policy for spec: %q resulted: %+v with error: %v", test.spec, p, err)
+		}
+	}
+}
+
+func TestStage(t *testing.T) {
+	defer func(tick time.Duration) { Tick = tick }(Tick)
+	Tick = 10 * time.Millisecond
+
+	oncall, manager := register("oncall"), register("manager")
+	p, err := ParsePolicy("50ms:oncall,150ms:oncall+manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memStore{}
+	if err := SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	in := make(chan pingd.HostStatus)
+	out := make(chan pingd.HostStatus, 100)
+	go ack.NewStage(time.Hour)(in, out)
+	stage := make(chan pingd.HostStatus, 100)
+	go NewStage(p)(out, stage)
+
+	next := func(ch <-chan pingd.HostStatus, name string) pingd.HostStatus {
+		select {
+		case h := <-ch:
+			return h
+		case <-time.After(time.Second):
+			t.Fatalf("Missing event for %s", name)
+		}
+		return pingd.HostStatus{}
+	}
+	none := func(ch <-chan pingd.HostStatus, name string) {
+		select {
+		case h := <-ch:
+			t.Errorf("Unexpected event for %s: %s %s", name, h.Host, h.Event)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	in <- pingd.HostStatus{Host: "h1", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+	in <- pingd.HostStatus{Host: "h2", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+	next(stage, "stage")
+	next(stage, "stage")
+	if len(store.list) != 2 {
+		t.Errorf("Got %d escalations saved, expected 2", len(store.list))
+	}
+
+	// h2 is acknowledged and doesn't escalate
+	time.Sleep(10 * time.Millisecond)
+	if err := ack.Acknowledge("h2", "ops", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	h := next(oncall, "oncall")
+	if h.Host != "h1" || h.Event != pingd.EventEscalation || !h.Down || h.Reason == nil || !strings.HasPrefix(h.Message, "down for") {
+		t.Errorf("Got event %s %s %t %q, expected h1 escalation", h.Host, h.Event, h.Down, h.Message)
+	}
+	none(manager, "manager")
+	for _, ch := range []<-chan pingd.HostStatus{oncall, manager} {
+		if h := next(ch, "second step"); h.Host != "h1" || h.Event != pingd.EventEscalation {
+			t.Errorf("Got event %s %s, expected h1 escalation", h.Host, h.Event)
+		}
+	}
+	if len(store.list) != 2 || store.list[0].Step != 2 || len(store.list[0].Notified) != 2 {
+		t.Errorf("Incorrect escalations saved %+v", store.list)
+	}
+
+	// the notifiers escalated to are notified when the host is up
+	in <- pingd.HostStatus{Host: "h1", Down: false, Event: pingd.EventUp}
+	in <- pingd.HostStatus{Host: "h2", Down: false, Event: pingd.EventUp}
+	for _, ch := range []<-chan pingd.HostStatus{oncall, manager} {
+		if h := next(ch, "up"); h.Host != "h1" || h.Event != pingd.EventUp {
+			t.Errorf("Got event %s %s, expected h1 UP", h.Host, h.Event)
+		}
+	}
+	none(oncall, "oncall")
+	if list := Escalations(); len(list) != 0 {
+		t.Errorf("Escalations kept after hosts went up: %+v", list)
+	}
+
+	// escalations go on after a restart
+	store.list = []Escalation{{Host: "h3", Since: time.Now().Add(-time.Minute)}}
+	if err := SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if h := next(oncall, "restart"); h.Host != "h3" || h.Event != pingd.EventEscalation {
+		t.Errorf("Got event %s %s, expected h3 escalation", h.Host, h.Event)
+	}
+	in <- pingd.HostStatus{Host: "h3", Down: false, Event: pingd.EventUp}
+	next(oncall, "h3 up")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/agent"
+	_ "github.com/weaming/pingd/dbping"
+	_ "github.com/weaming/pingd/dnsping"
+	"github.com/weaming/pingd/execping"
+	_ "github.com/weaming/pingd/grpcping"
+	_ "github.com/weaming/pingd/httping"
+	_ "github.com/weaming/pingd/mailping"
+	"github.com/weaming/pingd/ping"
+	_ "github.com/weaming/pingd/tcping"
+	_ "github.com/weaming/pingd/udping"
+	_ "github.com/weaming/pingd/wsping"
+)
+
+// See flags
+var (
+	central    string
+	name       string
+	interval   time.Duration
+	pluginArgs string
+)
+
+func main() {
+	flag.StringVar(&central, "central", "http://localhost:7700", "central pingd HTTP receiver URL")
+	flag.StringVar(&name, "name", "", "agent name, as given in the agent option of its hosts")
+	flag.DurationVar(&interval, "interval", 10*time.Second, "seconds between each poll")
+	flag.DurationVar(&ping.TimeOut, "timeOut", 5*time.Second, "seconds for single ping timeout")
+	flag.StringVar(&pluginArgs, "pluginArgs", "", "arguments the Nagios plugins of exec hosts can be given, e.g. 'check_disk=-w -c [0-9]*% -p /*;check_load=-r'")
+	flag.Parse()
+
+	// plugins can only be given the arguments allowed, as hosts come from the central pingd
+	for _, plugin := range strings.Split(pluginArgs, ";") {
+		if i := strings.Index(plugin, "="); i > 0 {
+			execping.PluginArgs[plugin[:i]] = strings.Fields(plugin[i+1:])
+		}
+	}
+
+	if name == "" {
+		log.Fatal("missing agent name")
+	}
+
+	a := &agent.Agent{
+		Central:  central,
+		Name:     name,
+		Token:    os.Getenv("PINGD_AGENT_TOKEN"),
+		Probe:    pingd.NewProbeFunc(ping.TimeOut),
+		Interval: interval,
+	}
+	a.Run()
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"flag"
+	"log"
 	"os"
 	"os/signal"
 	"time"
 
 	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/agent"
 	"github.com/weaming/pingd/io/redis"
 	"github.com/weaming/pingd/io/redisHub"
+	"github.com/weaming/pingd/io/webhook"
 	"github.com/weaming/pingd/ping"
+	"github.com/weaming/pingd/silence"
 )
 
 // See flags
@@ -20,6 +24,8 @@ var (
 	interval       time.Duration
 	listenAddr     string
 	hubTopicPrefix string
+	hubURL         string
+	deadLetters    string
 )
 
 func main() {
@@ -30,15 +36,30 @@ func main() {
 	flag.DurationVar(&ping.TimeOut, "timeOut", 5*time.Second, "seconds for single ping timeout")
 	flag.StringVar(&listenAddr, "listen", ":8080", "webserver listen address")
 	flag.StringVar(&hubTopicPrefix, "hubTopic", "admin/ping", "Topic for https://hub.drink.cafe")
+	flag.StringVar(&hubURL, "hub", redisHub.API_URL, "URL the hub messages are posted to")
+	flag.StringVar(&deadLetters, "deadLetters", "", "file keeping the hub messages failing to post, as JSON lines")
 	flag.Parse()
 
+	// messages are signed when a secret is shared with the hub
+	redisHub.Hub.URLs = []string{hubURL}
+	redisHub.Hub.Secret = os.Getenv("PINGD_HUB_SECRET")
+	if deadLetters != "" {
+		redisHub.Hub.DeadLetter = webhook.FileDeadLetter(deadLetters)
+	}
+
+	agent.Token = os.Getenv("PINGD_AGENT_TOKEN")
+	if err := silence.SetStore(redis.NewSilenceStore(redisAddr, redisDB, "pingSilences")); err != nil {
+		log.Fatal(err)
+	}
+
 	pool := &pingd.Pool{
 		Interval:  interval,
 		FailLimit: failLimit,
-		Ping:      redisHub.NewPingMap(ping.TimeOut).Ping,
+		Probe:     agent.NewProbeFunc(redisHub.NewPingMap(ping.TimeOut).Probe),
 		Receive:   redisHub.NewReceiverFunc(listenAddr, redisAddr, redisDB, "pingStart", "pingStop", "pingHostList"),
-		Notify:    redisHub.NewNotifierFunc(redisAddr, redisDB, "up", "down", hubTopicPrefix),
+		Notify:    pingd.Pipe(redisHub.NewNotifierFunc(redisAddr, redisDB, "up", "down", hubTopicPrefix), silence.NewStage()),
 		Load:      redis.NewLoaderFunc(redisAddr, redisDB, "pingHostList"),
+		Ingest:    redisHub.NewIngesterFunc(redisAddr, redisDB, "pingResult"),
 	}
 	pool.Start()
 
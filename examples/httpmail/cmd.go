@@ -2,47 +2,133 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
-	"github.com/jordan-wright/email"
-
 	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/ack"
+	"github.com/weaming/pingd/agent"
+	"github.com/weaming/pingd/execping"
+	"github.com/weaming/pingd/group"
+	_ "github.com/weaming/pingd/httping"
 	"github.com/weaming/pingd/io/http"
 	"github.com/weaming/pingd/io/mail"
 	"github.com/weaming/pingd/io/std"
+	_ "github.com/weaming/pingd/mailping"
 	"github.com/weaming/pingd/ping"
+	"github.com/weaming/pingd/silence"
+	_ "github.com/weaming/pingd/tcping"
+	"github.com/weaming/pingd/template"
+	_ "github.com/weaming/pingd/wsping"
 )
 
 // See flags
 var (
 	emailAddr  string
+	fromAddr   string
 	listenAddr string
 
+	smtpHost string
+	smtpPort int
+	smtpUser string
+	smtpTLS  string
+
 	interval  time.Duration
 	failLimit int
+	flapHigh  float64
+	remind    time.Duration
+	groupWait time.Duration
+	groupBy   string
+	templates string
+
+	pluginArgs string
 )
 
 func main() {
-	flag.StringVar(&emailAddr, "email", "me@example.org", "email recipient for notificiations")
+	flag.StringVar(&emailAddr, "email", "me@example.org", "comma separated email recipients for notificiations")
+	flag.StringVar(&fromAddr, "from", "pingd@example.org", "email sender of the notifications")
+	flag.StringVar(&smtpHost, "smtp", "localhost", "SMTP server the notifications are sent through")
+	flag.IntVar(&smtpPort, "smtpPort", 0, "SMTP server port, 465 with -smtpTLS tls and 587 otherwise if not set")
+	flag.StringVar(&smtpUser, "smtpUser", "", "SMTP user, with the password in PINGD_SMTP_PASSWORD")
+	flag.StringVar(&smtpTLS, "smtpTLS", "starttls", "SMTP encryption: starttls, tls for implicit TLS, or none")
 	flag.StringVar(&listenAddr, "listen", ":7700", "webserver listen address")
 	flag.IntVar(&failLimit, "failLimit", 4, "number failed ping attempts in a row to consider host down")
 	flag.DurationVar(&interval, "interval", 5*time.Second, "seconds between each ping")
 	flag.DurationVar(&ping.TimeOut, "timeOut", 5*time.Second, "seconds for single ping timeout")
+	flag.Float64Var(&flapHigh, "flapHigh", 0, "percent of state changes to hold the notifications of a flapping host, 0 disables it")
+	flag.DurationVar(&remind, "remind", time.Hour, "interval of the reminders of the hosts down not acknowledged")
+	flag.DurationVar(&groupWait, "groupWait", 30*time.Second, "wait for the events of hosts going up or down together, to mail them as a digest")
+	flag.StringVar(&groupBy, "groupBy", "tag,network", "comma separated keys hosts are grouped by in digests, among tag, network and reason")
+	flag.StringVar(&templates, "templates", "", "file of the text templates overriding the default messages")
+	flag.StringVar(&pluginArgs, "pluginArgs", "", "arguments the Nagios plugins of exec hosts can be given, e.g. 'check_disk=-w -c [0-9]*% -p /*;check_load=-r'")
 	flag.Parse()
 
+	// plugins can only be given the arguments allowed, as hosts can be added by HTTP
+	for _, plugin := range strings.Split(pluginArgs, ";") {
+		if i := strings.Index(plugin, "="); i > 0 {
+			execping.PluginArgs[plugin[:i]] = strings.Fields(plugin[i+1:])
+		}
+	}
+
+	if templates != "" {
+		t, err := template.ParseFiles(templates, "")
+		if err != nil {
+			log.Fatal(err)
+		}
+		mail.Templates = t
+	}
+
+	groupKey, err := group.ParseKey(groupBy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	grouping := group.Policy{Wait: groupWait, Interval: 5 * groupWait, Threshold: 3, By: groupKey}
+
+	// the mail server is checked as notifications are sent through it
+	scheme, port := "smtp", 587
+	if smtpTLS == "tls" {
+		scheme, port = "smtps", 465
+	}
+	if smtpPort == 0 {
+		smtpPort = port
+	}
+	mailServer := fmt.Sprintf("%s://%s:%d", scheme, smtpHost, smtpPort)
+	if smtpTLS == "starttls" {
+		mailServer += "#starttls"
+	}
+
+	smtp := mail.SMTP{
+		Host:        smtpHost,
+		Port:        smtpPort,
+		Username:    smtpUser,
+		Password:    os.Getenv("PINGD_SMTP_PASSWORD"),
+		StartTLS:    smtpTLS == "starttls",
+		ImplicitTLS: smtpTLS == "tls",
+		From:        fromAddr,
+		To:          strings.Split(emailAddr, ","),
+	}
+
 	// read non flag arguments as hosts to start monitoring
-	hosts := flag.Args()
+	hosts := append(flag.Args(), mailServer)
+
+	agent.Token = os.Getenv("PINGD_AGENT_TOKEN")
+
+	notify := pingd.Pipe(mail.NewSMTPNotifierFunc(smtp), ack.NewStage(remind), silence.NewStage(), group.NewStage(grouping))
 
 	var pool = &pingd.Pool{
-		Ping:      ping.Ping,
+		Probe:     agent.NewProbeFunc(pingd.NewProbeFunc(ping.TimeOut)), // hosts with #agent=name checked by remote agents
 		Interval:  interval,
 		FailLimit: failLimit,
-		Receive:   http.NewReceiverFunc(listenAddr),          // start/stop commands via HTTP
-		Notify:    mail.NewNotifierFunc(emailAddr, sendMail), // notify up/down via email
-		Load:      std.NewLoaderFunc(hosts),                  // load initial hosts from command line
+		FlapLow:   flapHigh * 2 / 3,
+		FlapHigh:  flapHigh,
+		Receive:   http.NewReceiverFunc(listenAddr), // start/stop commands via HTTP
+		Notify:    notify,                           // notify up/down via email, with reminders, unless silenced, in digests
+		Load:      std.NewLoaderFunc(hosts),         // load initial hosts from command line
+		Ingest:    http.NewIngesterFunc(),           // results of external probes via HTTP
 	}
 
 	pool.Start()
@@ -52,19 +138,3 @@ func main() {
 
 	<-c // Exit on interrupt
 }
-
-// Replace this localhost version with your appropriate mail function
-// https://github.com/jordan-wright/email#email
-func sendMail(recipient, message string) {
-	e := email.NewEmail()
-
-	// Change From to something more appropriate
-	e.From = recipient
-	e.To = []string{recipient}
-	e.Subject = message
-
-	err := e.Send("localhost:25", nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
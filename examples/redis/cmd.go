@@ -2,13 +2,17 @@ package main
 
 import (
 	"flag"
+	"log"
 	"os"
 	"os/signal"
 	"time"
 
 	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/ack"
+	"github.com/weaming/pingd/escalation"
 	"github.com/weaming/pingd/io/redis"
 	"github.com/weaming/pingd/ping"
+	"github.com/weaming/pingd/silence"
 )
 
 // See flags
@@ -16,7 +20,12 @@ var (
 	redisAddr string
 	redisDB   int
 	failLimit int
+	flapHigh  float64
 	interval  time.Duration
+	cluster   bool
+	ha        bool
+	remind    time.Duration
+	escalate  string
 )
 
 func main() {
@@ -25,21 +34,93 @@ func main() {
 	flag.IntVar(&failLimit, "failLimit", 6, "number failed ping attempts in a row to consider host down")
 	flag.DurationVar(&interval, "interval", 10*time.Second, "seconds between each ping")
 	flag.DurationVar(&ping.TimeOut, "timeOut", 5*time.Second, "seconds for single ping timeout")
+	flag.BoolVar(&cluster, "cluster", false, "split the hosts with the other instances using the same redis")
+	flag.BoolVar(&ha, "ha", false, "run as leader or standby of the other instances using the same redis")
+	flag.Float64Var(&flapHigh, "flapHigh", 0, "percent of state changes to hold the notifications of a flapping host, 0 disables it")
+	flag.DurationVar(&remind, "remind", time.Hour, "interval of the reminders of the hosts down not acknowledged")
+	flag.StringVar(&escalate, "escalate", "", "escalation policy of the hosts down not acknowledged, e.g. 15m:oncall,1h:oncall+manager")
 	flag.Parse()
 
+	// stages of the notifier
+	stages := []pingd.Stage{ack.NewStage(remind)}
+	if escalate != "" {
+		// escalated events are published on the channels of the notifiers
+		for _, name := range []string{"oncall", "manager"} {
+			escalation.Register(name, redis.NewNotifierFunc(redisAddr, redisDB, name+"-up", name+"-down"))
+		}
+		policy, err := escalation.ParsePolicy(escalate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stages = append(stages, escalation.NewStage(policy))
+	}
+	stages = append(stages, silence.NewStage())
+
 	var pool = &pingd.Pool{
 		Ping:      ping.Ping,
 		Interval:  interval,
 		FailLimit: failLimit,
+		FlapLow:   flapHigh * 2 / 3,
+		FlapHigh:  flapHigh,
 		Receive:   redis.NewReceiverFunc(redisAddr, redisDB, "start", "stop", "hostlist"),
-		Notify:    redis.NewNotifierFunc(redisAddr, redisDB, "up", "down"),
+		Notify:    pingd.Pipe(redis.NewNotifierFunc(redisAddr, redisDB, "up", "down"), stages...),
 		Load:      redis.NewLoaderFunc(redisAddr, redisDB, "hostlist"),
 	}
 
-	pool.Start()
+	go redis.ListenAcks(redisAddr, redisDB, "ack")
+
+	// silences, outages and escalations are loaded just before the pool
+	// starts, a standby loads them once leading, as saved by the leader
+	start := func() {
+		if err := silence.SetStore(redis.NewSilenceStore(redisAddr, redisDB, "silences")); err != nil {
+			log.Fatal(err)
+		}
+		if err := ack.SetStore(redis.NewOutageStore(redisAddr, redisDB, "outages")); err != nil {
+			log.Fatal(err)
+		}
+		if escalate != "" {
+			if err := escalation.SetStore(redis.NewEscalationStore(redisAddr, redisDB, "escalations")); err != nil {
+				log.Fatal(err)
+			}
+		}
+		pool.Start()
+	}
+
+	// instances leave the cluster on exit, for the others to take their hosts
+	leave := func() {}
+	if cluster {
+		// instances load their share of the hosts as they join
+		c := redis.NewCluster(redisAddr, redisDB, "start", "stop", "hostlist", "instances")
+		pool.Receive = c.Receiver()
+		pool.Load = nil
+		leave = c.Leave
+	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 
-	<-c // Exit on interrupt
+	if !ha {
+		start()
+		<-c // Exit on interrupt
+		leave()
+		return
+	}
+
+	// standby until leading, the loader takes over the hosts
+	// and their last status as saved by the previous leader
+	lease := redis.NewLease(redisAddr, redisDB, "leader")
+	go func() {
+		<-c // Exit on interrupt, handing over right away
+		leave()
+		lease.Release()
+		os.Exit(0)
+	}()
+	lease.Acquire()
+	start()
+
+	<-lease.Lost()
+	// the pool can't be stopped, so exit before another leader
+	// starts probing and notifying, the supervisor restarts
+	// this instance as a standby
+	log.Fatal("Lease lost, exiting")
 }
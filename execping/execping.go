@@ -0,0 +1,293 @@
+// Package execping runs external commands as checks, following the Nagios
+// plugin conventions, so existing check_* scripts can be used with pingd.
+//
+// Importing it registers the exec scheme, with targets like
+//
+//	exec:///usr/lib/nagios/plugins/check_disk?args=-w+20%25+-c+10%25+-p+/
+//
+// where the args query parameter is split on spaces and each arg parameter
+// is taken as one argument, in order. Only commands in PluginDirs can be run,
+// with the arguments allowed for them in PluginArgs.
+// The exit code gives the state:
+//
+//	0 OK        up
+//	1 WARNING   up, degraded
+//	2 CRITICAL  down
+//	3 UNKNOWN   down, unknown, as any other exit code
+//
+// and the first line of the output, up to the |, the message, the perfdata
+// after it being parsed into the probe metrics.
+package execping
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// PluginDirs are the directories commands can be run from, as targets
+// may come from the receivers anyone with access to them can add
+var PluginDirs = []string{
+	"/usr/lib/nagios/plugins",
+	"/usr/lib64/nagios/plugins",
+	"/usr/local/nagios/libexec",
+}
+
+// PluginArgs are the arguments the commands, by file name, can be given,
+// as filepath.Match patterns, e.g.
+//
+//	execping.PluginArgs["check_disk"] = []string{"-w", "-c", "[0-9]*%", "-p", "/", "/*"}
+//
+// Commands not listed can't be given any, so that the targets can't
+// make plugins like check_by_ssh run commands of their choice.
+var PluginArgs = map[string][]string{}
+
+// killWait is how long the output of a plugin killed is waited for
+const killWait = 100 * time.Millisecond
+
+// Nagios plugin exit codes
+const (
+	OK       = 0
+	Warning  = 1
+	Critical = 2
+	Unknown  = 3
+)
+
+func init() {
+	pingd.RegisterChecker("exec", checker{})
+}
+
+// checker runs exec targets
+type checker struct{}
+
+func (checker) Validate(t *pingd.Target) error {
+	_, _, err := command(t)
+	return err
+}
+
+func (checker) Check(t *pingd.Target) pingd.Probe {
+	path, args, err := command(t)
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = &stdout
+	setGroup(cmd)
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return pingd.Probe{Unknown: true, Err: err}
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	timer := time.NewTimer(t.Timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		// the processes the plugin started are killed with it, those
+		// which left its group and keep its output open are not waited
+		kill(cmd)
+		select {
+		case <-done:
+		case <-time.After(killWait):
+		}
+		return pingd.Probe{Latency: time.Since(start), Err: fmt.Errorf("timed out after %s", t.Timeout)}
+	}
+	latency := time.Since(start)
+
+	code := OK
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return pingd.Probe{Latency: latency, Unknown: true, Err: err}
+		}
+		code = exitErr.ExitCode()
+	}
+
+	text, metrics := ParseOutput(stdout.String())
+	p := pingd.Probe{Latency: latency, Message: text, Metrics: metrics}
+	switch code {
+	case OK:
+		p.Up = true
+	case Warning:
+		p.Up = true
+		p.Degraded = true
+	case Critical:
+		p.Err = errors.New(text)
+	default:
+		p.Unknown = true
+		p.Err = fmt.Errorf("unknown (exit code %d): %s", code, text)
+	}
+	return p
+}
+
+// command returns the path and arguments of the command of the target,
+// if it's in one of the PluginDirs
+func command(t *pingd.Target) (string, []string, error) {
+	if h := t.URL.Hostname(); h != "" && h != "localhost" {
+		return "", nil, fmt.Errorf("commands run locally, got host %s", h)
+	}
+
+	path := filepath.Clean(t.URL.Path)
+	allowed := false
+	for _, dir := range PluginDirs {
+		if filepath.Dir(path) == filepath.Clean(dir) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", nil, fmt.Errorf("%s is not in the plugin directories %s", path, strings.Join(PluginDirs, ", "))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return "", nil, fmt.Errorf("%s is not executable", path)
+	}
+
+	query := t.URL.Query()
+	args := strings.Fields(query.Get("args"))
+	args = append(args, query["arg"]...)
+	for _, arg := range args {
+		if !allowedArg(filepath.Base(path), arg) {
+			return "", nil, fmt.Errorf("argument %q is not allowed for %s", arg, path)
+		}
+	}
+	return path, args, nil
+}
+
+// allowedArg tells if the command can be given the argument, see PluginArgs
+func allowedArg(name, arg string) bool {
+	for _, pattern := range PluginArgs[name] {
+		if ok, _ := filepath.Match(pattern, arg); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseOutput returns the text of the first line of a plugin output and
+// the perfdata of all its lines
+func ParseOutput(output string) (string, []pingd.Metric) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+
+	text := lines[0]
+	var perfdata []string
+	if i := strings.Index(text, "|"); i >= 0 {
+		perfdata = append(perfdata, text[i+1:])
+		text = text[:i]
+	}
+
+	// long text lines may have more perfdata after a |
+	for _, line := range lines[1:] {
+		if i := strings.Index(line, "|"); i >= 0 {
+			perfdata = append(perfdata, line[i+1:])
+		}
+	}
+
+	var metrics []pingd.Metric
+	for _, p := range perfdata {
+		metrics = append(metrics, ParsePerfdata(p)...)
+	}
+	return strings.TrimSpace(text), metrics
+}
+
+// ParsePerfdata parses 'label'=value[UOM];[warn];[crit];[min];[max] items
+// separated by spaces, skipping the malformed ones
+func ParsePerfdata(s string) []pingd.Metric {
+	var metrics []pingd.Metric
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var label string
+		if s[0] == '\'' {
+			// quoted labels can have spaces, '' is a quote
+			end := 1
+			for end < len(s) {
+				if s[end] == '\'' {
+					if end+1 < len(s) && s[end+1] == '\'' {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			label = strings.Replace(s[1:min(end, len(s))], "''", "'", -1)
+			s = s[min(end+1, len(s)):]
+		} else {
+			i := strings.IndexAny(s, "= ")
+			if i < 0 {
+				break
+			}
+			label = s[:i]
+			s = s[i:]
+		}
+
+		var item string
+		if i := strings.IndexByte(s, ' '); i >= 0 {
+			item, s = s[:i], s[i:]
+		} else {
+			item, s = s, ""
+		}
+		if !strings.HasPrefix(item, "=") {
+			continue
+		}
+
+		fields := strings.Split(item[1:], ";")
+		m, ok := parseValue(fields[0])
+		if !ok {
+			continue
+		}
+		m.Label = label
+		for i, f := range fields[1:] {
+			switch i {
+			case 0:
+				m.Warn = f
+			case 1:
+				m.Crit = f
+			case 2:
+				m.Min = f
+			case 3:
+				m.Max = f
+			}
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// parseValue splits a perfdata value from its unit of measurement
+func parseValue(s string) (pingd.Metric, bool) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.-+eE", r)
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return pingd.Metric{}, false
+	}
+	return pingd.Metric{Value: value, Unit: s[i:]}, true
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
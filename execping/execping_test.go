@@ -0,0 +1,94 @@
+package execping
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pingd-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	PluginDirs = []string{dir}
+	PluginArgs = map[string][]string{"check_test": {"[0-9]", "[A-Z]*"}}
+
+	plugin := filepath.Join(dir, "check_test")
+	ioutil.WriteFile(plugin, []byte("#!/bin/sh\necho \"$2 - state $1 | load=$1;1;2;0\"\nexit $1\n"), 0755)
+	slow := filepath.Join(dir, "check_slow")
+	ioutil.WriteFile(slow, []byte("#!/bin/sh\nexec sleep 2\n"), 0755)
+
+	var checktests = []struct {
+		host     string
+		up       bool
+		degraded bool
+		unknown  bool
+		message  string
+	}{
+		{"exec://" + plugin + "?args=0+OK", true, false, false, "OK - state 0"},
+		{"exec://" + plugin + "?arg=1&arg=WARNING", true, true, false, "WARNING - state 1"},
+		{"exec://" + plugin + "?args=2+CRITICAL", false, false, false, "CRITICAL - state 2"},
+		{"exec://" + plugin + "?args=3+UNKNOWN", false, false, true, "UNKNOWN - state 3"},
+		{"exec://" + plugin + "?args=7+WEIRD", false, false, true, "WEIRD - state 7"},
+		{"exec://" + slow + "#timeout=100ms", false, false, false, ""},
+	}
+
+	probe := pingd.NewProbeFunc(time.Second)
+	for _, tt := range checktests {
+		p := probe(tt.host)
+		if p.Up != tt.up || p.Degraded != tt.degraded || p.Unknown != tt.unknown || p.Message != tt.message {
+			t.Errorf("Incorrect check for host: %s resulted: %+v", tt.host, p)
+		}
+	}
+
+	for _, host := range []string{"exec:///bin/sh", "exec://" + dir + "/../bin/sh", "exec://" + dir + "/missing", "exec://remote" + plugin, "exec://" + plugin + "?args=0+-c+id", "exec://" + slow + "?arg=1"} {
+		if _, err := pingd.ParseTarget(host); err == nil {
+			t.Errorf("Expected error for host %s", host)
+		}
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	text, metrics := ParseOutput("DISK OK - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968\n" +
+		"/ 15272 MB (77%);\n/boot 68 MB (69%); | /boot=68MB;88;93;0;98 'home dir'=69.5%;;;; time=0.01s")
+
+	if text != "DISK OK - free space: / 3326 MB (56%);" {
+		t.Errorf("Incorrect text: %q", text)
+	}
+	expected := []pingd.Metric{
+		{Label: "/", Value: 2643, Unit: "MB", Warn: "5948", Crit: "5958", Min: "0", Max: "5968"},
+		{Label: "/boot", Value: 68, Unit: "MB", Warn: "88", Crit: "93", Min: "0", Max: "98"},
+		{Label: "home dir", Value: 69.5, Unit: "%"},
+		{Label: "time", Value: 0.01, Unit: "s"},
+	}
+	if !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("Incorrect metrics: %+v", metrics)
+	}
+}
+
+// TestTimeoutChildren tests the timeout bounds plugins whose
+// children keep their output open
+func TestTimeoutChildren(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pingd-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	PluginDirs = []string{dir}
+
+	plugin := filepath.Join(dir, "check_fork")
+	ioutil.WriteFile(plugin, []byte("#!/bin/sh\nsleep 5\necho OK\n"), 0755)
+
+	start := time.Now()
+	p := pingd.NewProbeFunc(time.Second)("exec://" + plugin + "#timeout=200ms")
+	if elapsed := time.Since(start); p.Up || p.Err == nil || elapsed > time.Second {
+		t.Errorf("Incorrect check of a forking plugin resulted: %t after %s with error: %v", p.Up, elapsed, p.Err)
+	}
+}
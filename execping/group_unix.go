@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package execping
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setGroup starts the command in a process group of its own,
+// for kill to stop the processes it starts too
+func setGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// kill kills the process group of the command
+func kill(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
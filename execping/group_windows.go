@@ -0,0 +1,11 @@
+package execping
+
+import "os/exec"
+
+// setGroup does nothing, windows has no process groups to kill
+func setGroup(cmd *exec.Cmd) {}
+
+// kill kills the command
+func kill(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
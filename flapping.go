@@ -0,0 +1,44 @@
+package pingd
+
+// flapHistory is how many checks flap detection looks at, as Nagios does
+const flapHistory = 21
+
+// flapping tracks how often the state of a host changes, the most recent
+// changes weighing more, to tell when it starts and stops flapping
+type flapping struct {
+	low, high float64 // percents of state change to stop and start flapping
+	states    []bool  // outcome of the last checks, true when failed
+	on        bool
+}
+
+// record adds the outcome of a check, returning if the host started
+// or stopped flapping
+func (f *flapping) record(failed bool) (started, stopped bool) {
+	f.states = append(f.states, failed)
+	if len(f.states) > flapHistory {
+		f.states = f.states[1:]
+	}
+
+	percent := f.percent()
+	switch {
+	case !f.on && percent >= f.high:
+		f.on = true
+		return true, false
+	case f.on && percent < f.low:
+		f.on = false
+		return false, true
+	}
+	return false, false
+}
+
+// percent returns the weighted percent of state changes in the history,
+// from 0.8 for the oldest change to 1.2 for the newest
+func (f *flapping) percent() float64 {
+	changes := 0.0
+	for i := 1; i < len(f.states); i++ {
+		if f.states[i] != f.states[i-1] {
+			changes += 0.8 + 0.4*float64(i-1+flapHistory-len(f.states))/(flapHistory-2)
+		}
+	}
+	return changes * 100 / (flapHistory - 1)
+}
@@ -0,0 +1,106 @@
+package pingd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFlapping(t *testing.T) {
+	notifyCh := make(chan HostStatus, 100)
+	m := NewMonitor(HostStatus{Host: "h1"}, nil, notifyCh)
+	m.failLimit = 1
+	m.detectFlapping(20, 30)
+
+	up := Probe{Up: true}
+	down := Probe{Err: errors.New("timeout")}
+	var probes []Probe
+	// stable, then bouncing, then stable again
+	for i := 0; i < 5; i++ {
+		probes = append(probes, up)
+	}
+	for i := 0; i < 8; i++ {
+		probes = append(probes, down, up)
+	}
+	for i := 0; i < 21; i++ {
+		probes = append(probes, down)
+	}
+	for _, p := range probes {
+		m.Report(p)
+	}
+	close(notifyCh)
+
+	var events []string
+	for h := range notifyCh {
+		events = append(events, h.Event+" "+h.State())
+	}
+
+	expected := []string{
+		"DOWN DOWN", "UP UP", "DOWN DOWN", "UP UP", "DOWN DOWN", // 5 changes are 28.9%
+		"FLAPPING UP",       // the 6th makes 34.4%
+		"FLAPPING_END DOWN", // then the changes fade away
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Got events %q, expected %q", events, expected)
+	}
+	for i := range events {
+		if events[i] != expected[i] {
+			t.Errorf("Got events %q, expected %q", events, expected)
+			break
+		}
+	}
+}
+
+// TestFlapFailLimit tests hosts bouncing below the fail limit are
+// flapping, though their state doesn't change
+func TestFlapFailLimit(t *testing.T) {
+	notifyCh := make(chan HostStatus, 100)
+	m := NewMonitor(HostStatus{Host: "h1"}, nil, notifyCh)
+	m.failLimit = 3
+	m.detectFlapping(20, 30)
+
+	up := Probe{Up: true}
+	down := Probe{Err: errors.New("timeout")}
+	var probes []Probe
+	for i := 0; i < 5; i++ {
+		probes = append(probes, up)
+	}
+	for i := 0; i < 8; i++ {
+		probes = append(probes, down, up)
+	}
+	for i := 0; i < 21; i++ {
+		probes = append(probes, up)
+	}
+	for _, p := range probes {
+		m.Report(p)
+	}
+	close(notifyCh)
+
+	var events []string
+	for h := range notifyCh {
+		events = append(events, h.Event+" "+h.State())
+	}
+
+	expected := []string{"FLAPPING UP", "FLAPPING_END UP"}
+	if len(events) != len(expected) || events[0] != expected[0] || events[1] != expected[1] {
+		t.Errorf("Got events %q, expected %q", events, expected)
+	}
+}
+
+func TestFlapPercent(t *testing.T) {
+	tests := []struct {
+		states  []bool
+		percent float64
+	}{
+		{[]bool{false}, 0},
+		{[]bool{false, true}, 6},                         // the newest change weighs 1.2
+		{[]bool{true, false, false, false, false}, 5.68}, // older changes weigh less
+		{append(make([]bool, 20), true), 6},
+	}
+
+	for _, test := range tests {
+		f := &flapping{states: test.states}
+		if p := f.percent(); p < test.percent-0.01 || p > test.percent+0.01 {
+			t.Errorf("Got %.2f%% for %v, expected %.2f%%", p, test.states, test.percent)
+		}
+	}
+}
@@ -4,5 +4,9 @@ go 1.13
 
 require (
 	github.com/garyburd/redigo v1.6.0
+	github.com/gorilla/websocket v1.4.1
 	github.com/jordan-wright/email v0.0.0-20190819015918-041e0cec78b0
+	github.com/lib/pq v1.3.0
+	github.com/miekg/dns v1.1.25
+	google.golang.org/grpc v1.26.0
 )
@@ -0,0 +1,278 @@
+// Package group merges the events of hosts going up or down together, e.g.
+// hundreds of hosts behind an upstream outage, into digests.
+//
+// Events are grouped by a Key, e.g. by tag and network
+//
+//	key, err := group.ParseKey("tag,network")
+//	policy := group.Policy{Wait: 30 * time.Second, Interval: 5 * time.Minute, Threshold: 3, By: key}
+//	pool.Notify = pingd.Pipe(notifier, group.NewStage(policy))
+//
+// The first event of a group waits Wait for the others, then the events are
+// passed through when they are Threshold or less, and merged into a DIGEST
+// event otherwise. Later events of the group wait until Interval after it
+// was notified, as the group_wait and group_interval of Alertmanager.
+// The events of a host are kept in sequence, those following an event
+// waiting going in its group, but a DOWN and an UP event in a row cancel
+// out, leaving the host in the state last notified.
+package group
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// Tick is how often the stage looks for the groups to notify
+var Tick = time.Second
+
+// listed is how many hosts of each state a digest lists
+const listed = 10
+
+// Policy is how the events are grouped
+type Policy struct {
+	Wait      time.Duration // wait of the first event of a group
+	Interval  time.Duration // wait of the events of a group notified
+	Threshold int           // most events passed through, 1 if not set
+	By        Key           // all events in one group if not set
+}
+
+// Key returns the group of an event, named after the values it's grouped by
+type Key func(h pingd.HostStatus) string
+
+// keys are the keys events can be grouped by, by name
+var keys = map[string]Key{
+	"tag":     ByTag,
+	"network": ByNetwork,
+	"reason":  ByReason,
+}
+
+// ParseKey returns the key grouping by the comma separated names
+// of the keys, among tag, network and reason
+func ParseKey(spec string) (Key, error) {
+	var by []Key
+	for _, name := range strings.Split(spec, ",") {
+		k, ok := keys[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown group key %q, expected tag, network or reason", name)
+		}
+		by = append(by, k)
+	}
+	return By(by...), nil
+}
+
+// By returns the key grouping by all the given keys
+func By(by ...Key) Key {
+	return func(h pingd.HostStatus) string {
+		var names []string
+		for _, k := range by {
+			if name := k(h); name != "" {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, ",")
+	}
+}
+
+// ByTag groups the events by the tags of the hosts
+func ByTag(h pingd.HostStatus) string {
+	tags := pingd.Tags(h.Host)
+	if len(tags) == 0 {
+		return ""
+	}
+	return "tag=" + strings.Join(tags, "+")
+}
+
+// ByNetwork groups the events by the network of the hosts, the /24
+// or /64 of IP addresses and the parent domain of names
+func ByNetwork(h pingd.HostStatus) string {
+	name := pingd.Hostname(h.Host)
+	if ip := net.ParseIP(name); ip != nil {
+		mask := net.CIDRMask(64, 128)
+		if ip.To4() != nil {
+			ip, mask = ip.To4(), net.CIDRMask(24, 32)
+		}
+		return "network=" + (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+	}
+	if i := strings.Index(name, "."); i >= 0 && strings.Contains(name[i+1:], ".") {
+		name = name[i+1:]
+	}
+	return "network=" + name
+}
+
+// classes are the reason classes, by the words of the reasons
+var classes = []struct {
+	word, class string
+}{
+	{"timeout", "timeout"},
+	{"deadline exceeded", "timeout"},
+	{"refused", "refused"},
+	{"no such host", "dns"},
+	{"unreachable", "unreachable"},
+	{"certificate", "tls"},
+	{"tls", "tls"},
+}
+
+// ByReason groups the events by the class of the reasons the hosts are
+// down, e.g. timeout, refused or dns
+func ByReason(h pingd.HostStatus) string {
+	if h.Reason == nil {
+		return ""
+	}
+	if err, ok := h.Reason.(net.Error); ok && err.Timeout() {
+		return "reason=timeout"
+	}
+	reason := strings.ToLower(h.Reason.Error())
+	for _, c := range classes {
+		if strings.Contains(reason, c.word) {
+			return "reason=" + c.class
+		}
+	}
+	return "reason=other"
+}
+
+// batch is the events of a group waiting to be notified, in sequence
+type batch struct {
+	events []pingd.HostStatus
+	notify time.Time
+}
+
+// cancels tells if h cancels the previous event of its host, a DOWN
+// and an UP event in a row leaving the host in the state last notified
+func cancels(prev, h pingd.HostStatus) bool {
+	down := func(e pingd.HostStatus) bool {
+		return e.Event == pingd.EventDown || e.Event == pingd.EventUnreachable
+	}
+	return down(prev) && h.Event == pingd.EventUp || prev.Event == pingd.EventUp && down(h)
+}
+
+// cancel removes the last event of the host of h if h cancels it,
+// telling if it did, and if the batch still has events of the host
+func (b *batch) cancel(h pingd.HostStatus) (cancelled, pending bool) {
+	for i := len(b.events) - 1; i >= 0; i-- {
+		if b.events[i].Host != h.Host {
+			continue
+		}
+		if !cancels(b.events[i], h) {
+			return false, true
+		}
+		b.events = append(b.events[:i], b.events[i+1:]...)
+		for _, e := range b.events[:i] {
+			if e.Host == h.Host {
+				return true, true
+			}
+		}
+		return true, false
+	}
+	return false, false
+}
+
+// digest returns the events to notify, merged when more than threshold
+func (b *batch) digest(name string, threshold int) []pingd.HostStatus {
+	if len(b.events) <= threshold {
+		return b.events
+	}
+	if name == "" {
+		name = "all"
+	}
+
+	d := pingd.HostStatus{Host: name, Event: pingd.EventDigest, Group: b.events}
+	var states []string
+	hosts := make(map[string][]string)
+	for _, h := range b.events {
+		d.Down = d.Down || h.Down
+		state := h.Event
+		if state == "" || state == pingd.EventUp || state == pingd.EventDown {
+			state = h.State()
+		}
+		if _, ok := hosts[state]; !ok {
+			states = append(states, state)
+		}
+		hosts[state] = append(hosts[state], h.Host)
+	}
+
+	parts := make([]string, len(states))
+	for i, state := range states {
+		list := hosts[state]
+		parts[i] = fmt.Sprintf("%d %s: ", len(list), state)
+		if len(list) > listed {
+			parts[i] += strings.Join(list[:listed], ", ") + fmt.Sprintf(" and %d more", len(list)-listed)
+		} else {
+			parts[i] += strings.Join(list, ", ")
+		}
+	}
+	d.Message = strings.Join(parts, "; ")
+	return []pingd.HostStatus{d}
+}
+
+// NewStage returns the stage grouping the events following the policy
+func NewStage(p Policy) pingd.Stage {
+	if p.Threshold <= 0 {
+		p.Threshold = 1
+	}
+	if p.By == nil {
+		p.By = By()
+	}
+
+	return func(in <-chan pingd.HostStatus, out chan<- pingd.HostStatus) {
+		groups := make(map[string]*batch)
+		last := make(map[string]string) // group of the last event of the hosts waiting
+
+		ticker := time.NewTicker(Tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case h := <-in:
+				// the events of a host may be in different groups, e.g. by reason,
+				// so they go in the group of its pending event to stay in sequence
+				if name, ok := last[h.Host]; ok {
+					cancelled, pending := groups[name].cancel(h)
+					if !pending {
+						delete(last, h.Host)
+					}
+					if cancelled {
+						continue
+					}
+					if pending {
+						groups[name].events = append(groups[name].events, h)
+						continue
+					}
+				}
+
+				name := p.By(h)
+				b, ok := groups[name]
+				if !ok {
+					b = &batch{notify: time.Now().Add(p.Wait)}
+					groups[name] = b
+				}
+				b.events = append(b.events, h)
+				last[h.Host] = name
+
+			case now := <-ticker.C:
+				for name, b := range groups {
+					if now.Before(b.notify) {
+						continue
+					}
+					// groups without events for Interval start over
+					if len(b.events) == 0 {
+						delete(groups, name)
+						continue
+					}
+					for _, h := range b.events {
+						if last[h.Host] == name {
+							delete(last, h.Host)
+						}
+					}
+					for _, h := range b.digest(name, p.Threshold) {
+						out <- h
+					}
+					b.events = nil
+					b.notify = now.Add(p.Interval)
+				}
+			}
+		}
+	}
+}
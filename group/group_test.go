@@ -0,0 +1,143 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func TestKey(t *testing.T) {
+	key, err := ParseKey("tag, network,reason")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseKey("tag,rack"); err == nil {
+		t.Error("Expected error parsing unknown key")
+	}
+
+	tests := []struct {
+		host   string
+		reason error
+		group  string
+	}{
+		{"10.0.1.5", nil, "network=10.0.1.0/24"},
+		{"10.0.1.5#tag=db&tag=prod", nil, "tag=db+prod,network=10.0.1.0/24"},
+		{"tcp://10.0.2.5:5432#tag=db", errors.New("dial tcp 10.0.2.5:5432: connect: connection refused"), "tag=db,network=10.0.2.0/24,reason=refused"},
+		{"https://db1.dc1.example.org/health", context.DeadlineExceeded, "network=dc1.example.org,reason=timeout"},
+		{"example.org", errors.New("lookup example.org: no such host"), "network=example.org,reason=dns"},
+		{"[2001:db8::1]:80", errors.New("bad reply"), "network=2001:db8::/64,reason=other"},
+	}
+	for _, test := range tests {
+		if group := key(pingd.HostStatus{Host: test.host, Down: test.reason != nil, Reason: test.reason}); group != test.group {
+			t.Errorf("Incorrect group for host: %s resulted: %q expected: %q", test.host, group, test.group)
+		}
+	}
+}
+
+func TestStage(t *testing.T) {
+	defer func(tick time.Duration) { Tick = tick }(Tick)
+	Tick = 5 * time.Millisecond
+
+	in := make(chan pingd.HostStatus)
+	out := make(chan pingd.HostStatus, 100)
+	go NewStage(Policy{Wait: 30 * time.Millisecond, Interval: 100 * time.Millisecond, Threshold: 2, By: ByTag})(in, out)
+
+	next := func() pingd.HostStatus {
+		select {
+		case h := <-out:
+			return h
+		case <-time.After(time.Second):
+			t.Fatal("Missing event")
+		}
+		return pingd.HostStatus{}
+	}
+	none := func() {
+		select {
+		case h := <-out:
+			t.Errorf("Unexpected event %s %s", h.Host, h.Event)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	// few events pass through after the wait
+	in <- pingd.HostStatus{Host: "h1#tag=web", Down: true, Event: pingd.EventDown}
+	none()
+	if h := next(); h.Host != "h1#tag=web" || h.Event != pingd.EventDown {
+		t.Errorf("Got event %s %s, expected h1 DOWN", h.Host, h.Event)
+	}
+
+	// a storm is merged, the hosts back up before it's notified left out
+	for i := 0; i < 16; i++ {
+		in <- pingd.HostStatus{Host: fmt.Sprintf("db%d#tag=db", i), Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+	}
+	in <- pingd.HostStatus{Host: "db0#tag=db", Down: false, Event: pingd.EventUp}
+	in <- pingd.HostStatus{Host: "db15#tag=db", Down: true, Event: pingd.EventFlapping}
+	h := next()
+	if h.Host != "tag=db" || h.Event != pingd.EventDigest || !h.Down || len(h.Events()) != 16 {
+		t.Errorf("Got event %s %s %t with %d events, expected digest of 16", h.Host, h.Event, h.Down, len(h.Events()))
+	}
+	if !strings.HasPrefix(h.Message, "15 DOWN: db1#tag=db, ") || !strings.HasSuffix(h.Message, "and 5 more; 1 FLAPPING: db15#tag=db") {
+		t.Errorf("Incorrect digest message %q", h.Message)
+	}
+
+	// later events of a group notified wait for the interval
+	start := time.Now()
+	in <- pingd.HostStatus{Host: "db1#tag=db", Down: false, Event: pingd.EventUp}
+	if h := next(); h.Host != "db1#tag=db" || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Got event %s after %s, expected db1 after the interval", h.Host, time.Since(start))
+	}
+}
+
+// TestSequence tests the events of a host are kept in sequence, but
+// for a DOWN and an UP event in a row, which cancel each other
+func TestSequence(t *testing.T) {
+	defer func(tick time.Duration) { Tick = tick }(Tick)
+	Tick = 5 * time.Millisecond
+
+	in := make(chan pingd.HostStatus)
+	out := make(chan pingd.HostStatus, 100)
+	go NewStage(Policy{Wait: 30 * time.Millisecond, Interval: 30 * time.Millisecond, Threshold: 5, By: ByReason})(in, out)
+
+	// the group without reason is notified first
+	in <- pingd.HostStatus{Host: "h0", Message: "34.4% state changes", Event: pingd.EventFlapping}
+	time.Sleep(15 * time.Millisecond)
+
+	timeout := errors.New("timeout")
+	for _, h := range []pingd.HostStatus{
+		{Host: "h1", Down: true, Reason: timeout, Event: pingd.EventDown},
+		{Host: "h1", Event: pingd.EventUp}, // in another group, by reason
+		{Host: "h2", Down: true, Reason: timeout, Event: pingd.EventDown},
+		{Host: "h2", Down: true, Message: "34.4% state changes", Event: pingd.EventFlapping},
+		{Host: "h3", Down: true, Reason: timeout, Event: pingd.EventUnreachable},
+		{Host: "h3", Event: pingd.EventUp},
+		{Host: "h3", Down: true, Reason: timeout, Event: pingd.EventDown},
+	} {
+		in <- h
+	}
+
+	var events []string
+	deadline := time.After(200 * time.Millisecond)
+	for len(events) < 4 {
+		select {
+		case h := <-out:
+			events = append(events, h.Host+" "+h.Event)
+		case <-deadline:
+			t.Fatalf("Got events %q, expected 4", events)
+		}
+	}
+	// h2 FLAPPING, without reason, follows its DOWN in the timeout group
+	if fmt.Sprint(events) != fmt.Sprint([]string{"h0 FLAPPING", "h2 DOWN", "h2 FLAPPING", "h3 DOWN"}) {
+		t.Errorf("Got events %q, expected h0 FLAPPING, h2 DOWN, h2 FLAPPING and h3 DOWN in sequence", events)
+	}
+
+	select {
+	case h := <-out:
+		t.Errorf("Unexpected event %s %s", h.Host, h.Event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
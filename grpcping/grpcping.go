@@ -0,0 +1,87 @@
+// Package grpcping checks gRPC services with the standard health checking
+// protocol, calling grpc.health.v1.Health/Check.
+//
+// Importing it registers the grpc and grpcs schemes, the latter using TLS,
+// with targets like grpc://host:port/package.Service, where an empty service
+// asks for the health of the whole server. Check options, in the URL fragment:
+//
+//	tls    use TLS with the grpc scheme too
+//
+// plus the TLS options of pingd.Target.TLSConfig.
+package grpcping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/weaming/pingd"
+)
+
+func init() {
+	pingd.RegisterChecker("grpc", checker{})
+	pingd.RegisterChecker("grpcs", checker{})
+}
+
+// checker calls the health service of grpc targets
+type checker struct{}
+
+func (checker) Validate(t *pingd.Target) error {
+	if t.URL.Port() == "" {
+		return errors.New("missing port")
+	}
+	if useTLS(t) {
+		if _, err := t.TLSConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (checker) Check(t *pingd.Target) pingd.Probe {
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+
+	security := grpc.WithInsecure()
+	if useTLS(t) {
+		config, err := t.TLSConfig()
+		if err != nil {
+			return pingd.Probe{Err: err}
+		}
+		security = grpc.WithTransportCredentials(credentials.NewTLS(config))
+	}
+
+	start := time.Now()
+	conn, err := grpc.DialContext(ctx, t.URL.Host, security, grpc.WithBlock())
+	if err != nil {
+		return pingd.Probe{Err: fmt.Errorf("connecting to %s: %s", t.URL.Host, err)}
+	}
+	defer conn.Close()
+
+	service := strings.Trim(t.URL.Path, "/")
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	latency := time.Since(start)
+	if err != nil {
+		return pingd.Probe{Latency: latency, Err: err}
+	}
+
+	status := resp.GetStatus()
+	p := pingd.Probe{Latency: latency, Message: status.String()}
+	if status != healthpb.HealthCheckResponse_SERVING {
+		p.Err = fmt.Errorf("health status is %s", status)
+		return p
+	}
+	p.Up = true
+	return p
+}
+
+// useTLS reports whether the target is checked over TLS
+func useTLS(t *pingd.Target) bool {
+	return t.URL.Scheme == "grpcs" || t.Flag("tls")
+}
@@ -0,0 +1,51 @@
+package grpcping
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/weaming/pingd"
+)
+
+func TestCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("example.Serving", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("example.NotServing", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(server, hs)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+
+	host := "grpc://" + ln.Addr().String()
+	var checktests = []struct {
+		host    string
+		up      bool
+		message string
+	}{
+		{host, true, "SERVING"},
+		{host + "/example.Serving", true, "SERVING"},
+		{host + "/example.NotServing", false, "NOT_SERVING"},
+		{host + "/example.Missing", false, ""},
+		{"grpc://" + closed.Addr().String() + "#timeout=200ms", false, ""},
+	}
+
+	probe := pingd.NewProbeFunc(time.Second)
+	for _, tt := range checktests {
+		p := probe(tt.host)
+		if p.Up != tt.up || p.Message != tt.message {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", tt.host, p.Up, p.Message, p.Err)
+		}
+	}
+}
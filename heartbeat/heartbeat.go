@@ -0,0 +1,216 @@
+// Package heartbeat monitors jobs which report in, rather than probing them,
+// failing the check when no heartbeat arrives within the expected interval.
+//
+// Importing it registers the heartbeat scheme, with targets like
+//
+//	heartbeat://nightly-backup-5f2b9c#interval=24h&grace=1h
+//
+// where the host is the token the job uses to send its heartbeats, with
+// GET or POST, to /heartbeat/<token> on the HTTP receivers, or calling Beat.
+// Jobs can report their own failure at /heartbeat/<token>/fail, the request
+// body being the reason. Check options, in the URL fragment:
+//
+//	interval=24h   how often the job is expected to report, required
+//	grace=1h       how late the job can report, 0 by default
+//
+// Heartbeats are only recorded for the tokens of the hosts monitored, and
+// are kept in memory: after a restart, or once their host is started, jobs
+// have a full interval plus grace to report.
+package heartbeat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// Prefix is the path the HTTP receivers serve heartbeats under
+const Prefix = "/heartbeat/"
+
+// beat is the last heartbeat of a job
+type beat struct {
+	at   time.Time
+	fail string // reason reported by the job, if it failed
+}
+
+var beats = struct {
+	sync.Mutex
+	m       map[string]beat
+	watched map[string]map[string]time.Time // when the hosts of the tokens were started
+}{m: make(map[string]beat), watched: make(map[string]map[string]time.Time)}
+
+func init() {
+	pingd.RegisterChecker("heartbeat", checker{})
+}
+
+// Beat records a heartbeat of the job with the given token,
+// telling if it's the token of a host monitored
+func Beat(token string) bool {
+	return record(token, "")
+}
+
+// Fail records the job with the given token reported it failed,
+// telling if it's the token of a host monitored
+func Fail(token, reason string) bool {
+	if reason == "" {
+		reason = "failure reported"
+	}
+	return record(token, reason)
+}
+
+func record(token, fail string) bool {
+	beats.Lock()
+	defer beats.Unlock()
+
+	if len(beats.watched[token]) == 0 {
+		return false
+	}
+	beats.m[token] = beat{at: time.Now(), fail: fail}
+	return true
+}
+
+// last returns the last heartbeat of the job, the start of
+// its host standing for it when there's none yet
+func last(token string) (beat, bool) {
+	beats.Lock()
+	defer beats.Unlock()
+
+	b, ok := beats.m[token]
+	if !ok {
+		b.at = time.Now()
+		for _, start := range beats.watched[token] {
+			if start.Before(b.at) {
+				b.at = start
+			}
+		}
+	}
+	return b, ok
+}
+
+// checker checks heartbeat targets got their heartbeats in time
+type checker struct{}
+
+// window returns the interval and grace options of the target
+func window(t *pingd.Target) (interval, grace time.Duration, err error) {
+	s := t.Options.Get("interval")
+	if s == "" {
+		return 0, 0, errors.New("missing interval")
+	}
+	if interval, err = time.ParseDuration(s); err != nil || interval <= 0 {
+		return 0, 0, fmt.Errorf("invalid interval %q", s)
+	}
+	if s = t.Options.Get("grace"); s != "" {
+		if grace, err = time.ParseDuration(s); err != nil || grace < 0 {
+			return 0, 0, fmt.Errorf("invalid grace %q", s)
+		}
+	}
+	return interval, grace, nil
+}
+
+func (checker) Validate(t *pingd.Target) error {
+	if t.URL.Host == "" {
+		return errors.New("missing token")
+	}
+	_, _, err := window(t)
+	return err
+}
+
+// Watch starts recording the heartbeats of the token of the target
+func (checker) Watch(t *pingd.Target) {
+	beats.Lock()
+	defer beats.Unlock()
+
+	hosts := beats.watched[t.URL.Host]
+	if hosts == nil {
+		hosts = make(map[string]time.Time)
+		beats.watched[t.URL.Host] = hosts
+	}
+	if _, ok := hosts[t.Host]; !ok {
+		hosts[t.Host] = time.Now()
+	}
+}
+
+// Unwatch forgets the token of the target, and its heartbeats,
+// unless other hosts monitored have the same token
+func (checker) Unwatch(t *pingd.Target) {
+	beats.Lock()
+	defer beats.Unlock()
+
+	delete(beats.watched[t.URL.Host], t.Host)
+	if len(beats.watched[t.URL.Host]) == 0 {
+		delete(beats.watched, t.URL.Host)
+		delete(beats.m, t.URL.Host)
+	}
+}
+
+func (checker) Check(t *pingd.Target) pingd.Probe {
+	interval, grace, err := window(t)
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+
+	b, ok := last(t.URL.Host)
+	if b.fail != "" {
+		return pingd.Probe{Err: fmt.Errorf("job failed %s ago: %s", since(b.at), b.fail)}
+	}
+	if time.Since(b.at) > interval+grace {
+		if !ok {
+			return pingd.Probe{Err: fmt.Errorf("no heartbeat since start %s ago", since(b.at))}
+		}
+		return pingd.Probe{Err: fmt.Errorf("last heartbeat %s ago", since(b.at))}
+	}
+	if !ok {
+		return pingd.Probe{Up: true, Message: "waiting for first heartbeat"}
+	}
+	return pingd.Probe{Up: true, Message: fmt.Sprintf("last heartbeat %s ago", since(b.at))}
+}
+
+func since(t time.Time) time.Duration {
+	return time.Since(t).Round(time.Second)
+}
+
+// Handler records the heartbeats sent to /heartbeat/<token>
+// and the failures sent to /heartbeat/<token>/fail
+var Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, Prefix)
+	token := strings.TrimSuffix(path, "/fail")
+	if token == "" || strings.Contains(token, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "missing heartbeat token\n")
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD", "POST", "PUT":
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if token != path {
+		reason, _ := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+		if !Fail(token, strings.TrimSpace(string(reason))) {
+			unknown(w, token)
+			return
+		}
+		fmt.Fprintf(w, "failure of %s recorded\n", token)
+		return
+	}
+	if !Beat(token) {
+		unknown(w, token)
+		return
+	}
+	fmt.Fprintf(w, "heartbeat of %s recorded\n", token)
+})
+
+func unknown(w http.ResponseWriter, token string) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, "no host monitored with heartbeat token %s\n", token)
+}
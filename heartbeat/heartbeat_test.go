@@ -0,0 +1,101 @@
+package heartbeat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func TestCheck(t *testing.T) {
+	probe := pingd.NewProbeFunc(time.Second)
+	host := "heartbeat://job-1#interval=50ms&grace=50ms"
+	watch(t, host)
+
+	if p := probe(host); !p.Up || p.Message != "waiting for first heartbeat" {
+		t.Errorf("Incorrect check before first heartbeat: %+v", p)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if p := probe(host); p.Up || !strings.HasPrefix(p.Err.Error(), "no heartbeat since start") {
+		t.Errorf("Incorrect check without heartbeats: %+v", p)
+	}
+
+	Beat("job-1")
+	if p := probe(host); !p.Up {
+		t.Errorf("Incorrect check after heartbeat: %+v", p)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if p := probe(host); p.Up || !strings.HasPrefix(p.Err.Error(), "last heartbeat") {
+		t.Errorf("Incorrect check with late heartbeat: %+v", p)
+	}
+
+	Fail("job-1", "disk full")
+	if p := probe(host); p.Up || !strings.HasSuffix(p.Err.Error(), "disk full") {
+		t.Errorf("Incorrect check after failure: %+v", p)
+	}
+
+	for _, host := range []string{"heartbeat://job-2", "heartbeat://job-2#interval=daily", "heartbeat://#interval=1h"} {
+		if _, err := pingd.ParseTarget(host); err == nil {
+			t.Errorf("Expected error for host %s", host)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ts := httptest.NewServer(Handler)
+	defer ts.Close()
+	probe := pingd.NewProbeFunc(time.Second)
+
+	// only the tokens of the hosts monitored are recorded
+	resp, err := http.Get(ts.URL + "/heartbeat/job-3")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Heartbeat of a host not monitored recorded: %v %v", resp, err)
+	}
+	host := watch(t, "heartbeat://job-3#interval=1h")
+
+	resp, err = http.Get(ts.URL + "/heartbeat/job-3")
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("Heartbeat failed: %v %v", resp, err)
+	}
+	if p := probe("heartbeat://job-3#interval=1h"); !p.Up || !strings.HasPrefix(p.Message, "last heartbeat") {
+		t.Errorf("Incorrect check after heartbeat: %+v", p)
+	}
+
+	resp, err = http.Post(ts.URL+"/heartbeat/job-3/fail", "text/plain", strings.NewReader("exit code 1"))
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("Failure report failed: %v %v", resp, err)
+	}
+	if p := probe("heartbeat://job-3#interval=1h"); p.Up || !strings.HasSuffix(p.Err.Error(), "exit code 1") {
+		t.Errorf("Incorrect check after failure: %+v", p)
+	}
+
+	resp, _ = http.Get(ts.URL + "/heartbeat/")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected not found without token, got %s", resp.Status)
+	}
+
+	// the token is forgotten once its host is stopped
+	checker{}.Unwatch(host)
+	resp, _ = http.Get(ts.URL + "/heartbeat/job-3")
+	beats.Lock()
+	_, kept := beats.m["job-3"]
+	beats.Unlock()
+	if resp.StatusCode != http.StatusNotFound || kept {
+		t.Errorf("Heartbeat token kept after its host stopped, got %s", resp.Status)
+	}
+}
+
+// watch starts recording the heartbeats of host, as the pool does
+func watch(t *testing.T, host string) *pingd.Target {
+	target, err := pingd.ParseTarget(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker{}.Watch(target)
+	return target
+}
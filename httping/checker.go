@@ -0,0 +1,32 @@
+package httping
+
+import (
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func init() {
+	pingd.RegisterChecker("http", checker{})
+	pingd.RegisterChecker("https", checker{})
+}
+
+// checker checks http and https targets, see ParseOptions for the check options
+type checker struct{}
+
+func (checker) Validate(t *pingd.Target) error {
+	_, err := ParseOptions(t.Options)
+	return err
+}
+
+func (checker) Check(t *pingd.Target) pingd.Probe {
+	o, err := ParseOptions(t.Options)
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+	o.Timeout = t.Timeout
+
+	start := time.Now()
+	up, err := PingWithOptions(t.URL.String(), o)
+	return pingd.Probe{Up: up, Latency: time.Since(start), Err: err}
+}
@@ -2,23 +2,81 @@ package httping
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 )
 
 // Timeout sets the ping timeout in seconds
 var TimeOut = 5 * time.Second
 
-// Ping sends a HEAD command to a given URL, returns whether the host answers 200 or not
+// clients caches the HTTP clients by check options, so connections are reused
+var clients = struct {
+	sync.Mutex
+	m map[Options]cachedClient
+}{m: make(map[Options]cachedClient)}
+
+// cachedClient is a client and the version of the TLS files it was made with
+type cachedClient struct {
+	client *http.Client
+	files  string
+}
+
+// filesVersion returns the modification times and sizes of the TLS files
+// of the options, which change when the files are rotated
+func (o Options) filesVersion() string {
+	var version string
+	for _, name := range []string{o.CAFile, o.CertFile, o.KeyFile} {
+		if name == "" {
+			continue
+		}
+		if fi, err := os.Stat(name); err == nil {
+			version += fmt.Sprintf("%d/%d;", fi.ModTime().UnixNano(), fi.Size())
+		} else {
+			version += "missing;"
+		}
+	}
+	return version
+}
+
+// Ping sends a HEAD command to a given URL, returns whether the host answers 200 or not.
+// Check options can be given in the URL fragment, see ParseOptions.
 func Ping(url string) (up bool, err error) {
-	client := http.Client{
-		Timeout: TimeOut,
+	url, values, err := SplitURL(url)
+	if err != nil {
+		return false, err
+	}
+	o, err := ParseOptions(values)
+	if err != nil {
+		return false, err
+	}
+	return PingWithOptions(url, o)
+}
+
+// PingWithOptions sends a HEAD command, or the method of the options, to a given URL
+// using a client configured with the given options, returns whether the host answers
+// 200, or one of the status codes of the options, or not
+func PingWithOptions(url string, o Options) (up bool, err error) {
+	client, err := Client(o)
+	if err != nil {
+		return false, err
 	}
 
-	resp, err := client.Head(url)
+	method := o.Method
+	if method == "" {
+		method = "HEAD"
+	}
+	req, err := o.NewRequest(method, url)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return false, err
 	}
@@ -26,16 +84,43 @@ func Ping(url string) (up bool, err error) {
 	// Drain body just in case server misbehaves
 	defer func() {
 		n, _ := io.Copy(ioutil.Discard, resp.Body)
-		if n > 0 {
+		if n > 0 && method == "HEAD" {
 			log.Printf("warning: received %d bytes on response body for url %s", n, url)
 		}
 
 		resp.Body.Close()
 	}()
 
-	if resp.StatusCode == 200 {
+	if err := o.CheckFinalURL(resp); err != nil {
+		return false, err
+	}
+
+	if o.StatusOK(resp.StatusCode) {
 		return true, nil
 	}
 
 	return false, errors.New(resp.Status)
 }
+
+// Client returns the shared client for the given options, creating it
+// on first use, and again when its CA or certificate files change
+func Client(o Options) (*http.Client, error) {
+	clients.Lock()
+	defer clients.Unlock()
+
+	files := o.filesVersion()
+	cached, ok := clients.m[o]
+	if ok && cached.files == files {
+		return cached.client, nil
+	}
+
+	c, err := o.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		cached.client.CloseIdleConnections()
+	}
+	clients.m[o] = cachedClient{c, files}
+	return c, nil
+}
@@ -1,8 +1,16 @@
 package httping
 
 import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"testing"
 	"time"
+
+	"github.com/weaming/pingd"
 )
 
 var pingtests = []struct {
@@ -36,3 +44,106 @@ func TestPing(t *testing.T) {
 		}
 	}
 }
+
+func TestParseOptions(t *testing.T) {
+	_, values, err := SplitURL("https://u@example.org/health?x=1#insecure&redirects=3&bearer=t0k&timeout=2s&servername=svc.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := ParseOptions(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.InsecureSkipVerify || o.ServerName != "svc.internal" || o.MaxRedirects != 3 || o.BearerToken != "t0k" || o.Timeout != 2*time.Second {
+		t.Errorf("Incorrect options parsed: %+v", o)
+	}
+
+	for _, fragment := range []string{"redirects=-1", "timeout=soon", "cert=c.pem", "proxy=ftp://proxy"} {
+		values, _ := url.ParseQuery(fragment)
+		if _, err := ParseOptions(values); err == nil {
+			t.Errorf("Expected error for options %s", fragment)
+		}
+	}
+}
+
+func TestPingOptions(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/private", http.StatusFound)
+		case "/private":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	ca, err := ioutil.TempFile("", "pingd-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	ca.Close()
+
+	var optiontests = []struct {
+		url  string
+		ping bool
+	}{
+		{ts.URL + "/private#bearer=secret", false},
+		{ts.URL + "/private#bearer=secret&insecure", true},
+		{ts.URL + "/private#bearer=secret&ca=" + ca.Name(), true},
+		{ts.URL + "/private#ca=" + ca.Name(), false},
+		{ts.URL + "/redirect#bearer=secret&ca=" + ca.Name(), true},
+		{ts.URL + "/redirect#bearer=secret&ca=" + ca.Name() + "&redirects=none", false},
+		{ts.URL + "/redirect#bearer=secret&ca=" + ca.Name() + "&final_url=" + ts.URL + "/private", true},
+		{ts.URL + "/redirect#bearer=secret&ca=" + ca.Name() + "&final_url=" + ts.URL + "/other", false},
+	}
+
+	for _, tt := range optiontests {
+		ping, err := Ping(tt.url)
+		if ping != tt.ping {
+			t.Errorf("Incorrect ping for url: %s resulted: %t with error: %v", tt.url, ping, err)
+		}
+	}
+}
+
+func TestClientReload(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	ca, err := ioutil.TempFile("", "pingd-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ca.Name())
+	writeCA := func(servers ...*httptest.Server) {
+		f, err := os.Create(ca.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range servers {
+			pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+		}
+		f.Close()
+	}
+	writeCA(ts)
+
+	o := Options{Timeout: time.Second, TLSOptions: pingd.TLSOptions{CAFile: ca.Name()}}
+	c1, err := Client(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2, _ := Client(o); c2 != c1 {
+		t.Error("Client not reused for the same options")
+	}
+
+	// the rotated bundle is loaded
+	writeCA(ts, other)
+	if c3, _ := Client(o); c3 == c1 {
+		t.Error("Client reused after its CA file changed")
+	}
+}
@@ -0,0 +1,241 @@
+package httping
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// Options configure the HTTP client used to check a URL.
+//
+// They can be given per check in the URL fragment, which is never sent
+// to the server, e.g.
+//
+//	https://svc.internal/health#ca=/etc/pingd/ca.pem&cert=/etc/pingd/me.pem&key=/etc/pingd/me.key
+//
+// Basic auth credentials are also read from the URL user info.
+type Options struct {
+	Timeout time.Duration
+	Method  string // HEAD unless set
+	Status  string // accepted status codes and ranges, e.g. "200,300-399", only 200 unless set
+
+	// Authentication
+	Username    string
+	Password    string
+	BearerToken string
+
+	// TLS, parsed as for the other checks
+	pingd.TLSOptions
+
+	// Proxy is a http://, https:// or socks5:// URL, if empty the
+	// proxy is taken from the environment.
+	Proxy string
+
+	// Redirect policy
+	NoRedirects  bool   // check the first response instead of following redirects
+	MaxRedirects int    // hops to follow, 0 means the net/http default of 10
+	FinalURL     string // URL the redirects must end at, if set
+}
+
+// ParseOptions reads Options from the check options of a URL:
+//
+//	timeout=3s          request timeout
+//	method=GET          request method, HEAD by default
+//	status=200-399      accepted status codes and ranges, 200 by default
+//	user=, password=    basic auth
+//	bearer=             bearer token
+//	ca=                 CA bundle file
+//	cert=, key=         client certificate and key files
+//	insecure            skip server certificate verification
+//	servername=         server name to verify, the URL host by default
+//	proxy=              proxy URL
+//	redirects=          follow (default), none or max number of hops
+//	final_url=          URL the redirects must end at
+func ParseOptions(v url.Values) (Options, error) {
+	o := Options{
+		Timeout:     TimeOut,
+		Method:      strings.ToUpper(v.Get("method")),
+		Status:      v.Get("status"),
+		Username:    v.Get("user"),
+		Password:    v.Get("password"),
+		BearerToken: v.Get("bearer"),
+		Proxy:       v.Get("proxy"),
+		FinalURL:    v.Get("final_url"),
+	}
+
+	if s := v.Get("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return o, fmt.Errorf("invalid timeout %q: %s", s, err)
+		}
+		o.Timeout = d
+	}
+
+	var err error
+	if o.TLSOptions, err = pingd.ParseTLSOptions(v); err != nil {
+		return o, err
+	}
+
+	switch s := v.Get("redirects"); s {
+	case "", "follow":
+	case "none":
+		o.NoRedirects = true
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("invalid redirects %q, expected follow, none or a number of hops", s)
+		}
+		if n == 0 {
+			o.NoRedirects = true
+		}
+		o.MaxRedirects = n
+	}
+
+	if _, err := parseStatus(o.Status); err != nil {
+		return o, err
+	}
+
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil {
+			return o, fmt.Errorf("invalid proxy %q: %s", o.Proxy, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return o, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+	}
+
+	return o, nil
+}
+
+// SplitURL separates the check options in the fragment of rawurl
+// from the URL to request.
+func SplitURL(rawurl string) (string, url.Values, error) {
+	i := strings.Index(rawurl, "#")
+	if i < 0 {
+		return rawurl, url.Values{}, nil
+	}
+	v, err := url.ParseQuery(rawurl[i+1:])
+	if err != nil {
+		return rawurl[:i], nil, fmt.Errorf("invalid check options in %s: %s", rawurl, err)
+	}
+	return rawurl[:i], v, nil
+}
+
+// TLSConfig returns the TLS client configuration for the options.
+func (o Options) TLSConfig() (*tls.Config, error) {
+	return o.TLSOptions.Config()
+}
+
+// NewClient returns a HTTP client configured with the options
+func (o Options) NewClient() (*http.Client, error) {
+	tlsConfig, err := o.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: 1000,
+		MaxIdleConns:        1000,
+		IdleConnTimeout:     60 * time.Second,
+	}
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+
+	client := &http.Client{
+		Transport: tr,
+		Timeout:   o.Timeout,
+	}
+	switch {
+	case o.NoRedirects:
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	case o.MaxRedirects > 0:
+		max := o.MaxRedirects
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) > max {
+				return fmt.Errorf("stopped after %d redirects", max)
+			}
+			return nil
+		}
+	}
+
+	return client, nil
+}
+
+// NewRequest returns a request for url with the authentication of the options
+func (o Options) NewRequest(method, url string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if o.Username != "" || o.Password != "" {
+		req.SetBasicAuth(o.Username, o.Password)
+	}
+	if o.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+o.BearerToken)
+	}
+	return req, nil
+}
+
+// StatusOK reports whether the status code is accepted by the options
+func (o Options) StatusOK(code int) bool {
+	ranges, _ := parseStatus(o.Status)
+	for _, r := range ranges {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatus parses a list of status codes and ranges
+func parseStatus(s string) ([][2]int, error) {
+	if s == "" {
+		return [][2]int{{200, 200}}, nil
+	}
+
+	var ranges [][2]int
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		max := min
+		if len(bounds) == 2 {
+			if max, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || max < min {
+				return nil, fmt.Errorf("invalid status range %q", part)
+			}
+		}
+		ranges = append(ranges, [2]int{min, max})
+	}
+	return ranges, nil
+}
+
+// CheckFinalURL verifies the response comes from the expected final URL
+func (o Options) CheckFinalURL(resp *http.Response) error {
+	if o.FinalURL == "" {
+		return nil
+	}
+	if final := resp.Request.URL.String(); final != o.FinalURL {
+		return fmt.Errorf("redirected to %s, expected %s", final, o.FinalURL)
+	}
+	return nil
+}
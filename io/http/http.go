@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/ack"
+	"github.com/weaming/pingd/agent"
+	"github.com/weaming/pingd/heartbeat"
+	"github.com/weaming/pingd/silence"
 )
 
 type pingHTTP struct {
@@ -15,7 +20,31 @@ type pingHTTP struct {
 
 // ServeHTTP handles the incoming start/stop commands via HTTP
 func (p pingHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, heartbeat.Prefix) {
+		heartbeat.Handler.ServeHTTP(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, agent.Prefix) {
+		agent.Handler.ServeHTTP(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, ack.Prefix) {
+		ack.Handler.ServeHTTP(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, silence.Prefix) {
+		silence.Handler.ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path == ResultPath {
+		ResultHandler.ServeHTTP(w, r)
+		return
+	}
+
 	host := r.URL.Path[1:]
+	if r.URL.RawQuery != "" {
+		host += "?" + r.URL.RawQuery
+	}
 	if host == "" {
 		fmt.Fprint(w, "missing host on request\n")
 	}
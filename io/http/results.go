@@ -0,0 +1,74 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/weaming/pingd"
+)
+
+// ResultPath is where external probes submit their results
+const ResultPath = "/results"
+
+var (
+	resultLock sync.RWMutex
+	resultCh   chan<- pingd.Result
+)
+
+// ResultHandler takes the results POSTed by external probes, one JSON
+// object per line in the format of pingd.ParseResult, and passes them
+// to the ingester returned by NewIngesterFunc
+var ResultHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, "results must be POSTed\n")
+		return
+	}
+
+	resultLock.RLock()
+	defer resultLock.RUnlock()
+	if resultCh == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "result ingestion is not enabled\n")
+		return
+	}
+
+	// validate all the results before taking any
+	var results []pingd.Result
+	scanner := bufio.NewScanner(r.Body)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		result, err := pingd.ParseResult(scanner.Bytes())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "line %d: %s\n", line, err)
+			return
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	for _, result := range results {
+		resultCh <- result
+	}
+	fmt.Fprintf(w, "%d results taken\n", len(results))
+})
+
+// NewIngesterFunc returns the function that takes
+// into the pool the results received by ResultHandler
+func NewIngesterFunc() pingd.Ingester {
+	return func(ch chan<- pingd.Result) {
+		resultLock.Lock()
+		defer resultLock.Unlock()
+		resultCh = ch
+	}
+}
@@ -1,12 +1,16 @@
 package mail
 
 import (
-	"fmt"
 	"log"
 
 	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/template"
 )
 
+// Templates are the templates of the messages, message is
+// rendered for every event
+var Templates = template.Default
+
 // Mailer is a function which takes a email
 // address and a message and sends an email
 type Mailer func(recepient string, message string)
@@ -17,12 +21,7 @@ func NewNotifierFunc(recepient string, mailerFunc Mailer) pingd.Notifier {
 	return func(notify <-chan pingd.HostStatus) {
 		for {
 			host := <-notify
-			status := "UP"
-			if host.Down {
-				status = "DOWN"
-			}
-			message := fmt.Sprintf("host %s is %s", host.Host, status)
-
+			message := Templates.Render("message", host)
 			mailerFunc(recepient, message)
 			log.Printf(message)
 		}
@@ -0,0 +1,278 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jordan-wright/email"
+
+	"github.com/weaming/pingd"
+)
+
+// Route sends the events of the hosts it matches, by glob or by tag,
+// see pingd.Matcher, to its recipients
+type Route struct {
+	Host string   // glob of the host
+	Tag  string   // tag of the host, in its tag options
+	To   []string // recipients
+}
+
+// Match tells if the route matches the host
+func (r Route) Match(host string) bool {
+	return pingd.Matcher{Host: r.Host, Tag: r.Tag}.Match(host)
+}
+
+// SMTP mails the events through an SMTP server, Host:Port, with
+// STARTTLS, or TLS from the start when ImplicitTLS is set, and with
+// PLAIN auth when Username is set. The events are mailed to the
+// recipients of the routes matching their host, To if none does,
+// one message per route so the recipients of a route don't see those
+// of the others. The messages wait in a queue of Queue messages, 100
+// if not set, to be sent by another goroutine, so a slow server never
+// holds the events back; when the queue is full they're dropped as
+// dead letters. Failed sends are retried Retries times, 3 if not set
+// and none if negative, waiting Backoff, doubled on each retry, 1s if
+// not set, then passed to DeadLetter.
+type SMTP struct {
+	Host        string
+	Port        int // 465 with ImplicitTLS, 587 otherwise, if not set
+	Username    string
+	Password    string
+	StartTLS    bool        // require STARTTLS
+	ImplicitTLS bool        // TLS from the start, SMTPS
+	TLSConfig   *tls.Config // ServerName is Host if not set
+	Timeout     time.Duration
+	From        string
+	To          []string
+	Routes      []Route
+	Retries     int
+	Backoff     time.Duration
+	Queue       int
+	DeadLetter  DeadLetterFunc // logs the dead letters if not set
+}
+
+// DeadLetterFunc is a function which takes the messages failing
+// for good, or dropped as the queue is full
+type DeadLetterFunc func(to []string, msg []byte, err error)
+
+// ErrQueueFull is the error of the messages dropped as the queue is full
+var ErrQueueFull = errors.New("mail queue full")
+
+// withDefaults returns the configuration with the defaults set
+func (s SMTP) withDefaults() SMTP {
+	if s.Port == 0 {
+		s.Port = 587
+		if s.ImplicitTLS {
+			s.Port = 465
+		}
+	}
+	if s.TLSConfig == nil {
+		s.TLSConfig = &tls.Config{}
+	}
+	if s.TLSConfig.ServerName == "" {
+		s.TLSConfig = s.TLSConfig.Clone()
+		s.TLSConfig.ServerName = s.Host
+	}
+	if s.Timeout == 0 {
+		s.Timeout = 30 * time.Second
+	}
+	if s.Retries == 0 {
+		s.Retries = 3
+	}
+	if s.Backoff == 0 {
+		s.Backoff = time.Second
+	}
+	if s.Queue == 0 {
+		s.Queue = 100
+	}
+	if s.DeadLetter == nil {
+		s.DeadLetter = logDeadLetter
+	}
+	return s
+}
+
+// recipients returns the recipients of an event by route, those of
+// the routes matching any of the hosts of a digest, each route getting
+// its own message. Recipients of several routes are mailed once.
+func (s *SMTP) recipients(h pingd.HostStatus) [][]string {
+	var routes [][]string
+	seen := make(map[string]bool)
+	for _, r := range s.Routes {
+		matched := false
+		for _, event := range h.Events() {
+			if matched = r.Match(event.Host); matched {
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		var to []string
+		for _, rcpt := range r.To {
+			if !seen[rcpt] {
+				seen[rcpt] = true
+				to = append(to, rcpt)
+			}
+		}
+		if len(to) > 0 {
+			routes = append(routes, to)
+		}
+	}
+	if len(routes) == 0 && len(s.To) > 0 {
+		return [][]string{s.To}
+	}
+	return routes
+}
+
+// message returns the email of an event to the recipients,
+// rendered with the subject, body and html Templates
+func (s *SMTP) message(h pingd.HostStatus, to []string) ([]byte, error) {
+	e := email.NewEmail()
+	e.From = s.From
+	e.To = to
+	e.Subject = strings.Replace(Templates.Render("subject", h), "\n", " ", -1)
+	e.Text = []byte(Templates.Render("body", h))
+	e.HTML = []byte(Templates.RenderHTML("html", h))
+	return e.Bytes()
+}
+
+// deliver sends a message to the recipients through the server
+func (s *SMTP) deliver(to []string, msg []byte) error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: s.Timeout}
+
+	var conn net.Conn
+	var err error
+	if s.ImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, s.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	// the whole conversation must end in time
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server doesn't support STARTTLS")
+		}
+		if err := c.StartTLS(s.TLSConfig); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// permanent tells if the server rejected the message for good
+func permanent(err error) bool {
+	var e *textproto.Error
+	return errors.As(err, &e) && e.Code >= 500
+}
+
+// send sends a message, retrying on failure with backoff
+func (s *SMTP) send(to []string, msg []byte) error {
+	backoff := s.Backoff
+	for retry := 0; ; retry++ {
+		err := s.deliver(to, msg)
+		if err == nil || retry >= s.Retries || permanent(err) {
+			return err
+		}
+		log.Printf("ERROR mailing %s, retrying in %s: %s", strings.Join(to, ", "), backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// outgoing is a message waiting in the queue
+type outgoing struct {
+	host string
+	to   []string
+	msg  []byte
+}
+
+// sender sends the messages of the queue until it's closed
+func (s *SMTP) sender(queue <-chan outgoing) {
+	for m := range queue {
+		if err := s.send(m.to, m.msg); err != nil {
+			s.DeadLetter(m.to, m.msg, err)
+			continue
+		}
+		log.Printf("MAIL %s to %s", m.host, strings.Join(m.to, ", "))
+	}
+}
+
+// NewSMTPNotifierFunc returns the function mailing every event through
+// the SMTP server, failures are logged and never stop the notifier
+func NewSMTPNotifierFunc(s SMTP) pingd.Notifier {
+	s = s.withDefaults()
+	return func(notify <-chan pingd.HostStatus) {
+		queue := make(chan outgoing, s.Queue)
+		defer close(queue)
+		go s.sender(queue)
+
+		for h := range notify {
+			routes := s.recipients(h)
+			if len(routes) == 0 {
+				log.Println("ERROR no recipients to mail " + h.Host)
+				continue
+			}
+			for _, to := range routes {
+				msg, err := s.message(h, to)
+				if err != nil {
+					log.Printf("ERROR mailing %s: %s", h.Host, err)
+					continue
+				}
+				select {
+				case queue <- outgoing{h.Host, to, msg}:
+				default:
+					s.DeadLetter(to, msg, ErrQueueFull)
+				}
+			}
+		}
+	}
+}
+
+func logDeadLetter(to []string, msg []byte, err error) {
+	log.Printf("DEAD LETTER mail to %s: %s, %d bytes", strings.Join(to, ", "), err, len(msg))
+}
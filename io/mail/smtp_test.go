@@ -0,0 +1,328 @@
+package mail
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// fakeSMTP is an SMTP server keeping the messages it gets, failing
+// the first sessions with code fail
+type fakeSMTP struct {
+	net.Listener
+	cert *tls.Certificate
+	tls  bool // TLS from the start
+
+	mu       sync.Mutex
+	fails    int
+	fail     int
+	auth     string
+	messages []message
+}
+
+type message struct {
+	to   []string
+	data string
+}
+
+func newFakeSMTP(t *testing.T, implicitTLS bool) (*fakeSMTP, *x509.CertPool) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	ts.StartTLS()
+	ts.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{Listener: l, cert: &ts.TLS.Certificates[0], tls: implicitTLS}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, roots
+}
+
+func (s *fakeSMTP) port() int {
+	return s.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	if s.tls {
+		conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*s.cert}})
+	}
+
+	s.mu.Lock()
+	fail := 0
+	if s.fails > 0 {
+		s.fails--
+		fail = s.fail
+	}
+	s.mu.Unlock()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 fake ESMTP")
+
+	var to []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch cmd {
+		case "EHLO":
+			reply("250-fake")
+			if _, ok := conn.(*tls.Conn); !ok {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready")
+			conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*s.cert}})
+			r = bufio.NewReader(conn)
+		case "AUTH":
+			s.mu.Lock()
+			s.auth = strings.TrimSpace(line)
+			s.mu.Unlock()
+			reply("235 ok")
+		case "MAIL":
+			if fail != 0 {
+				reply(strconv.Itoa(fail) + " try again")
+				continue
+			}
+			reply("250 ok")
+		case "RCPT":
+			to = append(to, strings.TrimSpace(line[len("RCPT TO:"):]))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go on")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, message{to, data.String()})
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTP) received() []message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]message(nil), s.messages...)
+}
+
+func TestSMTP(t *testing.T) {
+	for _, implicitTLS := range []bool{false, true} {
+		server, roots := newFakeSMTP(t, implicitTLS)
+		defer server.Close()
+
+		notify := make(chan pingd.HostStatus)
+		go NewSMTPNotifierFunc(SMTP{
+			Host:        "127.0.0.1",
+			Port:        server.port(),
+			Username:    "pingd",
+			Password:    "secret",
+			StartTLS:    !implicitTLS,
+			ImplicitTLS: implicitTLS,
+			TLSConfig:   &tls.Config{RootCAs: roots},
+			From:        "pingd@example.org",
+			To:          []string{"ops@example.org"},
+			Routes:      []Route{{Tag: "db", To: []string{"dba@example.org", "ops@example.org"}}},
+		})(notify)
+
+		notify <- pingd.HostStatus{Host: "web1", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+		notify <- pingd.HostStatus{Host: "db1#tag=db", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+
+		var messages []message
+		for start := time.Now(); len(messages) < 2 && time.Since(start) < 2*time.Second; {
+			time.Sleep(10 * time.Millisecond)
+			messages = server.received()
+		}
+		if len(messages) != 2 {
+			t.Fatalf("Got %d messages, expected 2 with implicit TLS %t", len(messages), implicitTLS)
+		}
+		if to := strings.Join(messages[0].to, " "); to != "<ops@example.org>" {
+			t.Errorf("Got recipients %s, expected ops", to)
+		}
+		if to := strings.Join(messages[1].to, " "); to != "<dba@example.org> <ops@example.org>" {
+			t.Errorf("Got recipients %s, expected dba and ops", to)
+		}
+		data := messages[0].data
+		for _, part := range []string{"Subject: host web1 is DOWN", "Content-Type: text/plain", "Content-Type: text/html", "Reason: timeout"} {
+			if !strings.Contains(data, part) {
+				t.Errorf("Missing %q in message:\n%s", part, data)
+			}
+		}
+		server.mu.Lock()
+		if server.auth == "" {
+			t.Error("Missing authentication")
+		}
+		server.mu.Unlock()
+	}
+}
+
+func TestSMTPRetries(t *testing.T) {
+	server, _ := newFakeSMTP(t, false)
+	defer server.Close()
+
+	s := SMTP{Host: "127.0.0.1", Port: server.port(), From: "pingd@example.org", Retries: 2, Backoff: time.Millisecond}.withDefaults()
+	tests := []struct {
+		fails, code int
+		sent        bool
+	}{
+		{0, 0, true},
+		{2, 451, true},  // retried until sent
+		{3, 451, false}, // too many failures
+		{1, 550, false}, // rejected for good
+	}
+	for _, test := range tests {
+		server.mu.Lock()
+		server.fails, server.fail = test.fails, test.code
+		server.mu.Unlock()
+
+		before := len(server.received())
+		err := s.send([]string{"ops@example.org"}, []byte("Subject: test\r\n\r\ntest\r\n"))
+		if sent := len(server.received()) > before; sent != test.sent || (err == nil) != test.sent {
+			t.Errorf("Incorrect send after %d failures %d resulted: %t with error: %v", test.fails, test.code, sent, err)
+		}
+		server.mu.Lock()
+		server.fails = 0
+		server.mu.Unlock()
+	}
+
+	// unreachable servers fail in time
+	s.Port = 1
+	if err := s.send([]string{"ops@example.org"}, nil); err == nil {
+		t.Error("Expected error sending to a closed port")
+	}
+}
+
+// TestSMTPRoutes tests each route gets its own message, without
+// the recipients of the others
+func TestSMTPRoutes(t *testing.T) {
+	s := SMTP{
+		From: "pingd@example.org",
+		To:   []string{"ops@example.org"},
+		Routes: []Route{
+			{Tag: "web", To: []string{"web@example.org", "ops@example.org"}},
+			{Tag: "db", To: []string{"dba@example.org", "ops@example.org"}},
+			{Tag: "mq", To: []string{"mq@example.org"}},
+		},
+	}.withDefaults()
+
+	digest := pingd.HostStatus{Host: "all", Down: true, Event: pingd.EventDigest, Group: []pingd.HostStatus{
+		{Host: "web1#tag=web", Down: true, Event: pingd.EventDown},
+		{Host: "db1#tag=db", Down: true, Event: pingd.EventDown},
+	}}
+	tests := []struct {
+		h  pingd.HostStatus
+		to string
+	}{
+		{pingd.HostStatus{Host: "web1"}, "ops@example.org"},
+		{pingd.HostStatus{Host: "db1#tag=db"}, "dba@example.org ops@example.org"},
+		{digest, "web@example.org ops@example.org; dba@example.org"},
+	}
+	for _, test := range tests {
+		var routes []string
+		for _, to := range s.recipients(test.h) {
+			routes = append(routes, strings.Join(to, " "))
+		}
+		if to := strings.Join(routes, "; "); to != test.to {
+			t.Errorf("Incorrect recipients for host: %s resulted: %s", test.h.Host, to)
+		}
+	}
+
+	msg, err := s.message(digest, []string{"dba@example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := string(msg); !strings.Contains(data, "To: dba@example.org\r\n") || strings.Contains(data, "web@example.org") {
+		t.Errorf("Incorrect recipients in message:\n%s", data)
+	}
+}
+
+// TestSMTPQueue tests a server not answering doesn't hold the
+// events back, the messages overflowing the queue being dropped
+func TestSMTPQueue(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			if _, err := l.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	dropped := 0
+	notify := make(chan pingd.HostStatus)
+	defer close(notify)
+	go NewSMTPNotifierFunc(SMTP{
+		Host:    "127.0.0.1",
+		Port:    l.Addr().(*net.TCPAddr).Port,
+		Timeout: time.Second,
+		From:    "pingd@example.org",
+		To:      []string{"ops@example.org"},
+		Retries: -1,
+		Queue:   1,
+		DeadLetter: func(to []string, msg []byte, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == ErrQueueFull {
+				dropped++
+			}
+		},
+	})(notify)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		notify <- pingd.HostStatus{Host: "web" + strconv.Itoa(i), Down: true, Event: pingd.EventDown}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Events held back for %s", elapsed)
+	}
+
+	// one message is sent, one waits, the others are dropped
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if dropped < 3 {
+		t.Errorf("Got %d messages dropped, expected at least 3", dropped)
+	}
+}
@@ -0,0 +1,317 @@
+package redis
+
+import (
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/weaming/pingd"
+)
+
+var (
+	// ClusterHeartbeat is how often instances register themselves
+	// and rebalance the hosts
+	ClusterHeartbeat = 5 * time.Second
+
+	// ClusterTimeout is how long an instance can go without
+	// a heartbeat before its hosts are taken by the others
+	ClusterTimeout = 15 * time.Second
+)
+
+// replicas is the number of points of each instance on the ring,
+// the more the evener the hosts are split
+const replicas = 64
+
+// Ring is a consistent hash ring splitting hosts between instances,
+// so only the hosts of an instance joining or leaving are moved
+type Ring struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// NewRing returns the ring of the given instances
+func NewRing(instances []string) *Ring {
+	r := &Ring{owners: make(map[uint32]string, len(instances)*replicas)}
+	for _, instance := range instances {
+		for i := 0; i < replicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + instance))
+			r.points = append(r.points, point)
+			r.owners[point] = instance
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the instance monitoring host, empty if there are none
+func (r *Ring) Owner(host string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	point := crc32.ChecksumIEEE([]byte(host))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= point })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// command is a start or stop command received on pub/sub
+type command struct {
+	start bool
+	host  string
+}
+
+// Cluster is an instance monitoring its share of the hosts of listKey
+type Cluster struct {
+	id         string
+	redisAddr  string
+	redisDB    int
+	startKey   string
+	stopKey    string
+	listKey    string
+	clusterKey string
+	conn       redis.Conn
+	members    []string
+	ring       *Ring
+	running    map[string]bool
+	leave      chan struct{}
+	left       chan struct{}
+	leaving    sync.Once
+
+	startHostCh chan<- pingd.HostStatus
+	stopHostCh  chan<- pingd.HostStatus
+}
+
+// NewCluster returns the instance splitting the hosts of listKey between
+// the instances registered on clusterKey, and taking the start/stop
+// commands of the hosts it owns, see Receiver
+func NewCluster(redisAddr string, redisDB int, startKey, stopKey, listKey, clusterKey string) *Cluster {
+	servername, _ := os.Hostname()
+	return &Cluster{
+		id:         fmt.Sprintf("%s-%d", servername, os.Getpid()),
+		redisAddr:  redisAddr,
+		redisDB:    redisDB,
+		startKey:   startKey,
+		stopKey:    stopKey,
+		listKey:    listKey,
+		clusterKey: clusterKey,
+		ring:       NewRing(nil),
+		running:    make(map[string]bool),
+		leave:      make(chan struct{}),
+		left:       make(chan struct{}),
+	}
+}
+
+// NewClusterReceiverFunc returns the receiver of a new Cluster
+func NewClusterReceiverFunc(redisAddr string, redisDB int, startKey, stopKey, listKey, clusterKey string) pingd.Receiver {
+	return NewCluster(redisAddr, redisDB, startKey, stopKey, listKey, clusterKey).Receiver()
+}
+
+// Receiver returns the function that joins the cluster, and listens on
+// redis for start/stop commands, taking those of the hosts it owns. It
+// loads the hosts itself so pools using it need no Loader.
+func (c *Cluster) Receiver() pingd.Receiver {
+	return func(startHostCh, stopHostCh chan<- pingd.HostStatus) {
+		c.conn = NewRedisConn(c.redisAddr, c.redisDB, "cluster")
+		c.startHostCh = startHostCh
+		c.stopHostCh = stopHostCh
+
+		commands := make(chan command, 10)
+		go subscribe(NewRedisConn(c.redisAddr, c.redisDB, "cluster-pubsub"), c.startKey, c.stopKey, commands)
+
+		log.Println("BOOT Joining cluster as " + c.id)
+		ticker := time.NewTicker(ClusterHeartbeat)
+		defer ticker.Stop()
+
+		c.heartbeat()
+		c.rebalance()
+		for {
+			select {
+			case cmd := <-commands:
+				c.command(cmd)
+			case <-ticker.C:
+				c.heartbeat()
+				c.rebalance()
+			case <-c.leave:
+				c.deregister(c.conn)
+				close(c.left)
+				return
+			}
+		}
+	}
+}
+
+// Leave deregisters the instance, for the others to take its hosts
+// right away rather than after ClusterTimeout, its heartbeats stop
+func (c *Cluster) Leave() {
+	c.leaving.Do(func() { close(c.leave) })
+	select {
+	case <-c.left:
+		return
+	case <-time.After(ClusterHeartbeat):
+	}
+
+	// the receiver isn't running, or is stuck
+	conn, err := redis.Dial("tcp", c.redisAddr, redis.DialDatabase(c.redisDB), redis.DialConnectTimeout(ClusterHeartbeat))
+	if err != nil {
+		log.Println("ERROR leaving cluster:", err)
+		return
+	}
+	defer conn.Close()
+	c.deregister(conn)
+}
+
+// deregister removes the instance from the cluster
+func (c *Cluster) deregister(conn redis.Conn) {
+	if _, err := conn.Do("ZREM", c.clusterKey, c.id); err != nil {
+		log.Println("ERROR leaving cluster:", err)
+		return
+	}
+	log.Println("CLUSTER Left as " + c.id)
+}
+
+// subscribe forwards the start/stop commands published on redis
+func subscribe(conn redis.Conn, startKey, stopKey string, commands chan<- command) {
+	psc := redis.PubSubConn{Conn: conn}
+	psc.Subscribe(startKey, stopKey)
+
+	for {
+		switch n := psc.Receive().(type) {
+		case redis.Message:
+			commands <- command{start: n.Channel == startKey, host: string(n.Data)}
+		case redis.Subscription:
+			log.Println("BOOT Listening to " + n.Channel)
+		case error:
+			log.Printf("error: %v\n", n)
+			return
+		}
+	}
+}
+
+// serverTime returns the time of the redis server, the same
+// for all the instances whatever the skew of their clocks
+func serverTime(conn redis.Conn) (time.Time, error) {
+	t, err := redis.Int64s(conn.Do("TIME"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(t) != 2 {
+		return time.Time{}, fmt.Errorf("invalid TIME reply %v", t)
+	}
+	return time.Unix(t[0], t[1]*int64(time.Microsecond)), nil
+}
+
+// heartbeat registers the instance and updates the ring with the
+// instances which sent a heartbeat in time, as of the redis clock
+func (c *Cluster) heartbeat() {
+	now, err := serverTime(c.conn)
+	if err != nil {
+		log.Println("ERROR cluster heartbeat:", err)
+		return
+	}
+	c.conn.Send("ZADD", c.clusterKey, now.Unix(), c.id)
+	c.conn.Send("ZREMRANGEBYSCORE", c.clusterKey, "-inf", now.Add(-ClusterTimeout).Unix())
+	c.conn.Send("ZRANGE", c.clusterKey, 0, -1)
+	c.conn.Flush()
+	c.conn.Receive()
+	c.conn.Receive()
+	members, err := redis.Strings(c.conn.Receive())
+	if err != nil {
+		log.Println("ERROR cluster heartbeat:", err)
+		return
+	}
+
+	if strings.Join(members, " ") != strings.Join(c.members, " ") {
+		log.Printf("CLUSTER %d instances: %s", len(members), strings.Join(members, ", "))
+		c.members = members
+		c.ring = NewRing(members)
+	}
+}
+
+// rebalance starts the hosts the instance owns and stops those it doesn't
+func (c *Cluster) rebalance() {
+	hosts, err := redis.Strings(c.conn.Do("SMEMBERS", c.listKey))
+	if err != nil {
+		log.Println("ERROR cluster rebalance:", err)
+		return
+	}
+
+	owned := make(map[string]bool)
+	for _, host := range hosts {
+		if c.ring.Owner(host) == c.id {
+			owned[host] = true
+		}
+	}
+
+	started, stopped := 0, 0
+	for host := range owned {
+		if !c.running[host] {
+			c.start(c.status(host))
+			started++
+		}
+	}
+	for host := range c.running {
+		if !owned[host] {
+			c.stop(host)
+			stopped++
+		}
+	}
+	if started > 0 || stopped > 0 {
+		log.Printf("CLUSTER rebalanced, %d hosts started, %d stopped, %d owned", started, stopped, len(owned))
+	}
+}
+
+// command keeps the host list up to date, as any instance, and starts
+// or stops the host if the instance owns it
+func (c *Cluster) command(cmd command) {
+	host := cmd.host
+	if !cmd.start {
+		if _, err := c.conn.Do("SREM", c.listKey, host); err != nil {
+			log.Println("ERROR", err)
+		}
+		if c.running[host] {
+			c.stop(host)
+		}
+		return
+	}
+
+	down := false
+	if strings.HasSuffix(host, downSuffix) {
+		down = true
+		host = strings.Replace(host, downSuffix, "", 1)
+	}
+	if _, err := c.conn.Do("SADD", c.listKey, host); err != nil {
+		log.Println("ERROR", err)
+	}
+	if c.ring.Owner(host) == c.id {
+		c.start(pingd.HostStatus{Host: host, Down: down})
+	}
+}
+
+// status returns the last status of host saved by the notifier,
+// for a host moving between instances to keep its outage
+func (c *Cluster) status(host string) pingd.HostStatus {
+	h, err := LoadHost(c.conn, host)
+	if err != nil && err != redis.ErrNil {
+		log.Println("ERROR loading status of " + host + ". Assuming UP")
+	}
+	return h
+}
+
+func (c *Cluster) start(h pingd.HostStatus) {
+	c.running[h.Host] = true
+	c.startHostCh <- h
+}
+
+func (c *Cluster) stop(host string) {
+	delete(c.running, host)
+	c.stopHostCh <- pingd.HostStatus{Host: host}
+}
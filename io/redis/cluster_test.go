@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func TestRing(t *testing.T) {
+	hosts := make([]string, 3000)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("host%d.example.org", i)
+	}
+
+	if owner := NewRing(nil).Owner(hosts[0]); owner != "" {
+		t.Errorf("Got owner %q for an empty ring", owner)
+	}
+
+	ring := NewRing([]string{"a-1", "b-1", "c-1"})
+	owners := make(map[string]string, len(hosts))
+	count := make(map[string]int)
+	for _, host := range hosts {
+		owners[host] = ring.Owner(host)
+		count[owners[host]]++
+	}
+
+	// every instance gets a fair share
+	for _, instance := range []string{"a-1", "b-1", "c-1"} {
+		if count[instance] < len(hosts)/6 {
+			t.Errorf("Instance %s got %d of %d hosts", instance, count[instance], len(hosts))
+		}
+	}
+
+	// the split doesn't depend on the order of the instances
+	for _, host := range hosts {
+		if owner := NewRing([]string{"c-1", "a-1", "b-1"}).Owner(host); owner != owners[host] {
+			t.Fatalf("Host %s owned by %s and %s", host, owners[host], owner)
+		}
+	}
+
+	// only the hosts of the instance leaving move
+	ring = NewRing([]string{"a-1", "c-1"})
+	for _, host := range hosts {
+		if owner := ring.Owner(host); owners[host] != "b-1" && owner != owners[host] {
+			t.Errorf("Host %s moved from %s to %s", host, owners[host], owner)
+		}
+	}
+
+	// only hosts moving to the instance joining move
+	ring = NewRing([]string{"a-1", "b-1", "c-1", "d-1"})
+	for _, host := range hosts {
+		if owner := ring.Owner(host); owner != "d-1" && owner != owners[host] {
+			t.Errorf("Host %s moved from %s to %s", host, owners[host], owner)
+		}
+	}
+}
+
+// fakeCluster keeps the members of a cluster key with a clock of its own
+type fakeCluster struct {
+	sync.Mutex
+	now     int64
+	members map[string]int64
+}
+
+func (f *fakeCluster) do(args []string) string {
+	f.Lock()
+	defer f.Unlock()
+
+	var n int64
+	switch strings.ToUpper(args[0]) {
+	case "TIME":
+		return fmt.Sprintf("*2\r\n$%d\r\n%d\r\n$1\r\n0\r\n", len(fmt.Sprint(f.now)), f.now)
+	case "ZADD":
+		fmt.Sscan(args[2], &n)
+		f.members[args[3]] = n
+		return ":1\r\n"
+	case "ZREMRANGEBYSCORE":
+		fmt.Sscan(args[3], &n)
+		for member, score := range f.members {
+			if score <= n {
+				delete(f.members, member)
+			}
+		}
+		return ":0\r\n"
+	case "ZRANGE":
+		reply := fmt.Sprintf("*%d\r\n", len(f.members))
+		for member := range f.members {
+			reply += fmt.Sprintf("$%d\r\n%s\r\n", len(member), member)
+		}
+		return reply
+	case "ZREM":
+		delete(f.members, args[2])
+		return ":1\r\n"
+	case "SMEMBERS":
+		return "*0\r\n"
+	}
+	return "+OK\r\n"
+}
+
+func TestClusterHeartbeat(t *testing.T) {
+	// the redis clock is far from the local one
+	f := &fakeCluster{now: 1000, members: map[string]int64{"b-1": 990, "c-1": 980}}
+	ln := serveRedis(t, f.do)
+	defer ln.Close()
+
+	c := NewCluster(ln.Addr().String(), 0, "start", "stop", "hostlist", "instances")
+	c.id = "a-1"
+	go c.Receiver()(make(chan pingd.HostStatus, 10), make(chan pingd.HostStatus, 10))
+
+	// instances expire as of the redis clock
+	deadline := time.Now().Add(time.Second)
+	for {
+		f.Lock()
+		_, joined := f.members["a-1"]
+		_, alive := f.members["b-1"]
+		_, expired := f.members["c-1"]
+		f.Unlock()
+		if joined && alive && !expired {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Incorrect cluster members: %v", f.members)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.Leave()
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.members["a-1"]; ok {
+		t.Errorf("Instance still registered after leaving: %v", f.members)
+	}
+}
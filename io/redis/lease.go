@@ -0,0 +1,184 @@
+package redis
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	// LeaseTTL is how long the lease lasts without being renewed, how
+	// long a standby waits to take over when the leader goes away
+	LeaseTTL = 10 * time.Second
+
+	// LeaseRenew is how often the leader renews the lease,
+	// and the standby instances try to acquire it
+	LeaseRenew = 3 * time.Second
+)
+
+// only the holder of the lease can renew and release it
+const (
+	renewScript   = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+	releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+)
+
+// Lease is a leader lease on redis, for instances sharing it to run
+// in active/passive mode, the one holding the lease running the pool
+type Lease struct {
+	redisAddr string
+	redisDB   int
+	key       string
+	id        string
+	lost      chan struct{}
+	release   chan struct{}
+	released  sync.Once
+}
+
+// NewLease returns the lease on key of this instance
+func NewLease(redisAddr string, redisDB int, key string) *Lease {
+	servername, _ := os.Hostname()
+	return &Lease{
+		redisAddr: redisAddr,
+		redisDB:   redisDB,
+		key:       key,
+		id:        fmt.Sprintf("%s-%d", servername, os.Getpid()),
+		lost:      make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+}
+
+// deadlineConn is a connection which can't be used past its deadline,
+// whatever the timeouts its user sets
+type deadlineConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+func (c deadlineConn) bound(t time.Time) time.Time {
+	if t.IsZero() || t.After(c.deadline) {
+		return c.deadline
+	}
+	return t
+}
+
+func (c deadlineConn) SetDeadline(t time.Time) error      { return c.Conn.SetDeadline(c.bound(t)) }
+func (c deadlineConn) SetReadDeadline(t time.Time) error  { return c.Conn.SetReadDeadline(c.bound(t)) }
+func (c deadlineConn) SetWriteDeadline(t time.Time) error { return c.Conn.SetWriteDeadline(c.bound(t)) }
+
+// dial connects to redis for timeout at most, connecting and
+// running commands included, as the lease must not be waited
+// on longer than it lasts
+func (l *Lease) dial(timeout time.Duration) (redis.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	return redis.Dial("tcp", l.redisAddr,
+		redis.DialDatabase(l.redisDB),
+		redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+			conn, err := net.DialTimeout(network, addr, timeout)
+			if err != nil {
+				return nil, err
+			}
+			conn.SetDeadline(deadline)
+			return deadlineConn{conn, deadline}, nil
+		}))
+}
+
+// do runs a command on a new connection, so redis going away and
+// back doesn't break the lease, giving up after timeout
+func (l *Lease) do(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := l.dial(timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.Do(cmd, args...)
+}
+
+// Acquire blocks until the instance holds the lease, then
+// keeps renewing it until it's lost or released
+func (l *Lease) Acquire() {
+	log.Printf("BOOT Standing by for lease %s as %s", l.key, l.id)
+	var acquired time.Time
+	for {
+		acquired = time.Now()
+		ok, err := redis.String(l.do(LeaseRenew, "SET", l.key, l.id, "NX", "PX", int64(LeaseTTL/time.Millisecond)))
+		if err == nil && ok == "OK" {
+			break
+		}
+		if err != nil && err != redis.ErrNil {
+			log.Println("ERROR acquiring lease:", err)
+		}
+		time.Sleep(LeaseRenew)
+	}
+
+	log.Printf("BOOT Leading as %s", l.id)
+	go l.renew(acquired)
+}
+
+// renew extends the lease every LeaseRenew, the lease is lost when
+// another instance holds it, or when it wasn't renewed in time. The
+// instance steps down LeaseRenew before the lease may expire, the
+// renewals being bounded by the time left, so that it never runs
+// while another instance may hold the lease.
+func (l *Lease) renew(renewed time.Time) {
+	ttl, every := LeaseTTL, LeaseRenew
+	timer := time.NewTimer(every)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-l.release:
+			return
+		case <-timer.C:
+		}
+
+		// renewed is when the last renewal was sent, so the
+		// lease lasts at least ttl after it
+		deadline := renewed.Add(ttl - every)
+		start := time.Now()
+		left := deadline.Sub(start)
+		if left <= 0 {
+			log.Printf("ERROR lease %s not renewed for %s", l.key, time.Since(renewed).Round(time.Millisecond))
+			break
+		}
+
+		n, err := redis.Int(l.do(left, "EVAL", renewScript, 1, l.key, l.id, int64(ttl/time.Millisecond)))
+		if err == nil && n == 1 {
+			renewed = start
+			timer.Reset(every)
+			continue
+		}
+		if err == nil {
+			log.Printf("ERROR lease %s taken by another instance", l.key)
+			break
+		}
+
+		// retry until the deadline
+		log.Println("ERROR renewing lease:", err)
+		wait := every
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+		timer.Reset(wait)
+	}
+
+	close(l.lost)
+}
+
+// Lost is closed when the lease is lost, then the instance must stop
+// monitoring and notifying at once, as another one is taking over
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release gives up the lease, for a standby to take over right away
+func (l *Lease) Release() {
+	l.released.Do(func() { close(l.release) })
+	if _, err := l.do(LeaseRenew, "EVAL", releaseScript, 1, l.key, l.id); err != nil {
+		log.Println("ERROR releasing lease:", err)
+	}
+}
@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis keeps one key, answering SET NX PX and the lease scripts
+type fakeRedis struct {
+	sync.Mutex
+	value   string
+	expires time.Time
+	stalled chan struct{} // the scripts hang until it's closed, if set
+}
+
+func (f *fakeRedis) get() string {
+	if time.Now().After(f.expires) {
+		f.value = ""
+	}
+	return f.value
+}
+
+func (f *fakeRedis) set(value string) {
+	f.Lock()
+	defer f.Unlock()
+	f.value, f.expires = value, time.Now().Add(time.Hour)
+}
+
+func (f *fakeRedis) serve(t *testing.T) net.Listener {
+	return serveRedis(t, f.do)
+}
+
+// serveRedis serves the commands, as their arguments, with do
+// answering them in the redis protocol
+func serveRedis(t *testing.T, do func(args []string) string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					var n int
+					if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+						return
+					}
+					args := make([]string, n)
+					for i := range args {
+						var l int
+						fmt.Fscanf(r, "$%d\r\n", &l)
+						arg := make([]byte, l+2)
+						r.Read(arg)
+						args[i] = string(arg[:l])
+					}
+					fmt.Fprint(conn, do(args))
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func (f *fakeRedis) do(args []string) string {
+	f.Lock()
+	stalled := f.stalled
+	f.Unlock()
+	if stalled != nil && strings.ToUpper(args[0]) == "EVAL" {
+		<-stalled
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		if f.get() != "" {
+			return "$-1\r\n"
+		}
+		var ms int
+		fmt.Sscan(args[5], &ms)
+		f.value, f.expires = args[2], time.Now().Add(time.Duration(ms)*time.Millisecond)
+		return "+OK\r\n"
+	case "EVAL":
+		if f.get() != args[4] {
+			return ":0\r\n"
+		}
+		if args[1] == renewScript {
+			var ms int
+			fmt.Sscan(args[5], &ms)
+			f.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		} else {
+			f.value = ""
+		}
+		return ":1\r\n"
+	}
+	return "+OK\r\n"
+}
+
+func TestLease(t *testing.T) {
+	defer func(ttl, renew time.Duration) { LeaseTTL, LeaseRenew = ttl, renew }(LeaseTTL, LeaseRenew)
+	LeaseTTL = 300 * time.Millisecond
+	LeaseRenew = 50 * time.Millisecond
+
+	f := &fakeRedis{}
+	ln := f.serve(t)
+	defer ln.Close()
+
+	leader := NewLease(ln.Addr().String(), 0, "leader")
+	leader.Acquire()
+
+	standby := NewLease(ln.Addr().String(), 0, "leader")
+	standby.id += "-standby"
+	acquired := make(chan bool)
+	go func() {
+		standby.Acquire()
+		close(acquired)
+	}()
+
+	// the leader keeps renewing the lease past its TTL
+	select {
+	case <-acquired:
+		t.Fatal("Standby acquired the lease held by the leader")
+	case <-leader.Lost():
+		t.Fatal("Leader lost the lease")
+	case <-time.After(2 * LeaseTTL):
+	}
+
+	leader.Release()
+	select {
+	case <-acquired:
+	case <-time.After(LeaseTTL):
+		t.Fatal("Standby didn't take over the released lease")
+	}
+
+	// someone else takes the lease
+	f.set("intruder")
+	select {
+	case <-standby.Lost():
+	case <-time.After(LeaseTTL):
+		t.Fatal("Lease taken by another instance not lost")
+	}
+	standby.Release()
+}
+
+func TestLeaseStalled(t *testing.T) {
+	defer func(ttl, renew time.Duration) { LeaseTTL, LeaseRenew = ttl, renew }(LeaseTTL, LeaseRenew)
+	LeaseTTL = 300 * time.Millisecond
+	LeaseRenew = 50 * time.Millisecond
+
+	f := &fakeRedis{}
+	ln := f.serve(t)
+	defer ln.Close()
+
+	leader := NewLease(ln.Addr().String(), 0, "leader")
+	leader.Acquire()
+	acquired := time.Now()
+
+	// redis stops answering the renewals, the leader steps down
+	// before the lease may expire
+	stalled := make(chan struct{})
+	defer close(stalled)
+	f.Lock()
+	f.stalled = stalled
+	f.Unlock()
+
+	select {
+	case <-leader.Lost():
+		if lost := time.Since(acquired); lost >= LeaseTTL {
+			t.Errorf("Leader stepped down after %s, expected it before the lease TTL %s", lost, LeaseTTL)
+		}
+	case <-time.After(2 * LeaseTTL):
+		t.Fatal("Leader didn't step down")
+	}
+}
@@ -1,7 +1,6 @@
 package redis
 
 import (
-	"fmt"
 	"log"
 	"os"
 	"strconv"
@@ -10,6 +9,8 @@ import (
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/ack"
+	"github.com/weaming/pingd/template"
 )
 
 const (
@@ -21,6 +22,10 @@ const (
 	// adding it at the end eg "example.com down"
 	downSuffix   = " down"
 	StatusPrefix = "status-"
+
+	// SincePrefix is the prefix of the keys of the unix
+	// times the hosts down went down
+	SincePrefix = "since-"
 )
 
 func StartRedisHost(connKV redis.Conn, listKey, host string, startHostCh chan<- pingd.HostStatus) {
@@ -74,7 +79,7 @@ func NewReceiverFunc(redisAddr string, redisDB int, startKey, stopKey, listKey s
 		connKV := NewRedisConn(redisAddr, redisDB, "receive-kv")
 		conPubSub := NewRedisConn(redisAddr, redisDB, "receive-pubsub")
 
-		psc := redis.PubSubConn{conPubSub}
+		psc := redis.PubSubConn{Conn: conPubSub}
 		psc.Subscribe(startKey, stopKey)
 
 		for {
@@ -100,35 +105,161 @@ func NewReceiverFunc(redisAddr string, redisDB int, startKey, stopKey, listKey s
 	}
 }
 
+// NewIngesterFunc returns the function that listens on redis for the
+// results of external probes, published as JSON in the format of
+// pingd.ParseResult
+func NewIngesterFunc(redisAddr string, redisDB int, resultKey string) pingd.Ingester {
+	return func(resultCh chan<- pingd.Result) {
+		conPubSub := NewRedisConn(redisAddr, redisDB, "ingest-pubsub")
+
+		psc := redis.PubSubConn{Conn: conPubSub}
+		psc.Subscribe(resultKey)
+
+		for {
+			switch n := psc.Receive().(type) {
+			case redis.Message:
+				result, err := pingd.ParseResult(n.Data)
+				if err != nil {
+					log.Printf("ERROR invalid result %q: %v\n", n.Data, err)
+					continue
+				}
+				resultCh <- result
+
+			case redis.Subscription:
+				log.Println("BOOT Listening to " + n.Channel)
+			case error:
+				log.Printf("error: %v\n", n)
+				return
+			}
+		}
+	}
+}
+
+// ListenAcks acknowledges the outages of the hosts published on
+// ackKey, as "host" or "host author comment"
+func ListenAcks(redisAddr string, redisDB int, ackKey string) {
+	conPubSub := NewRedisConn(redisAddr, redisDB, "ack-pubsub")
+
+	psc := redis.PubSubConn{Conn: conPubSub}
+	psc.Subscribe(ackKey)
+
+	for {
+		switch n := psc.Receive().(type) {
+		case redis.Message:
+			fields := strings.SplitN(string(n.Data), " ", 3)
+			author, comment := "redis", ""
+			if len(fields) > 1 {
+				author = fields[1]
+			}
+			if len(fields) > 2 {
+				comment = fields[2]
+			}
+			if err := ack.Acknowledge(fields[0], author, comment); err != nil {
+				log.Println("ERROR", err)
+			}
+
+		case redis.Subscription:
+			log.Println("BOOT Listening to " + n.Channel)
+		case error:
+			log.Printf("error: %v\n", n)
+			return
+		}
+	}
+}
+
+// Templates are the templates of the messages published,
+// redis.up and redis.down
+var Templates = template.Default
+
 // NewNotifierFunc returns the function that
 // publishes on redis the up/down events
 func NewNotifierFunc(redisAddr string, redisDB int, upKey, downKey string) pingd.Notifier {
 	return func(notifyCh <-chan pingd.HostStatus) {
 		conn := NewRedisConn(redisAddr, redisDB, "notify")
 
-		var h pingd.HostStatus
+		var event pingd.HostStatus
 		for {
 			select {
-			case h = <-notifyCh:
-				switch h.Down {
-				// DOWN
-				case true:
-					log.Println("DOWN " + h.Host)
-					conn.Send("PUBLISH", downKey, fmt.Sprintf("%s %s", h.Host, h.Reason))
-					conn.Send("SET", StatusPrefix+h.Host, downStatus)
-					conn.Flush()
-				// UP
-				case false:
-					log.Println("UP " + h.Host)
-					conn.Send("PUBLISH", upKey, h.Host)
-					conn.Send("SET", StatusPrefix+h.Host, upStatus)
-					conn.Flush()
+			case event = <-notifyCh:
+				// digests are published host by host
+				for _, h := range event.Events() {
+					switch {
+					// a flapping host state is saved, its transitions
+					// are published when it stops flapping
+					case h.Event == pingd.EventFlapping:
+						log.Println("FLAPPING " + h.Host)
+						SaveStatus(conn, h)
+						conn.Flush()
+
+					// the status of the hosts unreachable is saved when they
+					// are notified DOWN, if still down once reachable, and
+					// that of the hosts reminded is already saved
+					case h.Event == pingd.EventUnreachable, h.Event == pingd.EventReminder:
+						log.Println(h.Event + " " + h.Host)
+
+					// escalations are published as DOWN to the notifiers
+					// escalated to, the status is saved by the main one
+					case h.Event == pingd.EventEscalation:
+						log.Println("ESCALATION " + h.Host)
+						conn.Do("PUBLISH", downKey, Templates.Render("redis.down", h))
+
+					// DOWN
+					case h.Down:
+						log.Println("DOWN " + h.Host)
+						conn.Send("PUBLISH", downKey, Templates.Render("redis.down", h))
+						SaveStatus(conn, h)
+						conn.Flush()
+
+					// UP
+					default:
+						log.Println("UP " + h.Host)
+						conn.Send("PUBLISH", upKey, Templates.Render("redis.up", h))
+						SaveStatus(conn, h)
+						conn.Flush()
+					}
 				}
 			}
 		}
 	}
 }
 
+// statusOf returns the status value saved for the host
+func statusOf(h pingd.HostStatus) string {
+	if h.Down {
+		return downStatus
+	}
+	return upStatus
+}
+
+// SaveStatus sends the commands saving the status of the host, and when
+// it went down, for its outage to be timed from its start after a restart.
+// The commands must be flushed.
+func SaveStatus(conn redis.Conn, h pingd.HostStatus) {
+	conn.Send("SET", StatusPrefix+h.Host, statusOf(h))
+	if h.Down {
+		conn.Send("SET", SincePrefix+h.Host, time.Now().Add(-h.Duration).Unix())
+	} else {
+		conn.Send("DEL", SincePrefix+h.Host)
+	}
+}
+
+// LoadHost returns the last status of host saved by the notifier,
+// with how long it's been down in Duration
+func LoadHost(conn redis.Conn, host string) (pingd.HostStatus, error) {
+	h := pingd.HostStatus{Host: host}
+	status, err := redis.String(conn.Do("GET", StatusPrefix+host))
+	if err != nil || status != downStatus {
+		return h, err
+	}
+
+	h.Down = true
+	// hosts saved down without the time are down from now on
+	if since, err := redis.Int64(conn.Do("GET", SincePrefix+host)); err == nil {
+		h.Duration = time.Since(time.Unix(since, 0))
+	}
+	return h, nil
+}
+
 func LoadStatus(conn redis.Conn, redisKey string) []pingd.HostStatus {
 	hosts, err := redis.Strings(conn.Do("SMEMBERS", redisKey))
 	if err != nil {
@@ -137,15 +268,11 @@ func LoadStatus(conn redis.Conn, redisKey string) []pingd.HostStatus {
 
 	statuses := []pingd.HostStatus{}
 	for _, host := range hosts {
-		var down bool
-		status, err := redis.String(conn.Do("GET", StatusPrefix+host))
+		status, err := LoadHost(conn, host)
 		if err != nil {
 			log.Println("ERROR loading status of " + host + ". Assuming UP")
 		}
-		if status == downStatus {
-			down = true
-		}
-		statuses = append(statuses, pingd.HostStatus{Host: host, Down: down})
+		statuses = append(statuses, status)
 	}
 	return statuses
 }
@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/ack"
+)
+
+// fakeKV keeps string keys and hashes, answering GET, SET, DEL,
+// HSET, HDEL and HGETALL, and the messages PUBLISH sends
+type fakeKV struct {
+	sync.Mutex
+	keys      map[string]string
+	hashes    map[string]map[string]string
+	published []string // channel and message
+}
+
+func (f *fakeKV) do(args []string) string {
+	f.Lock()
+	defer f.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		v, ok := f.keys[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		f.keys[args[1]] = args[2]
+	case "DEL":
+		delete(f.keys, args[1])
+	case "HSET":
+		if f.hashes == nil {
+			f.hashes = make(map[string]map[string]string)
+		}
+		if f.hashes[args[1]] == nil {
+			f.hashes[args[1]] = make(map[string]string)
+		}
+		f.hashes[args[1]][args[2]] = args[3]
+		return ":1\r\n"
+	case "HDEL":
+		delete(f.hashes[args[1]], args[2])
+		return ":1\r\n"
+	case "PUBLISH":
+		f.published = append(f.published, args[1]+" "+args[2])
+		return ":0\r\n"
+	case "HGETALL":
+		reply := fmt.Sprintf("*%d\r\n", 2*len(f.hashes[args[1]]))
+		for k, v := range f.hashes[args[1]] {
+			reply += fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(k), k, len(v), v)
+		}
+		return reply
+	}
+	return "+OK\r\n"
+}
+
+// TestLoadHost tests hosts loaded down keep the start of their outage
+func TestLoadHost(t *testing.T) {
+	f := &fakeKV{keys: map[string]string{StatusPrefix + "h3": downStatus}}
+	ln := serveRedis(t, f.do)
+	defer ln.Close()
+	conn := NewRedisConn(ln.Addr().String(), 0, "test")
+	defer conn.Close()
+
+	SaveStatus(conn, pingd.HostStatus{Host: "h1", Down: true, Duration: time.Hour})
+	SaveStatus(conn, pingd.HostStatus{Host: "h2", Down: true, Duration: time.Hour})
+	SaveStatus(conn, pingd.HostStatus{Host: "h2", Down: false, Duration: time.Hour})
+	conn.Flush()
+
+	tests := []struct {
+		host     string
+		down     bool
+		duration time.Duration
+	}{
+		{"h1", true, time.Hour},
+		{"h2", false, 0},
+		{"h3", true, 0}, // saved without the start of the outage
+	}
+	for _, test := range tests {
+		h, err := LoadHost(conn, test.host)
+		if err != nil || h.Down != test.down || h.Duration < test.duration || h.Duration > test.duration+2*time.Second {
+			t.Errorf("Incorrect status for host: %s resulted: %t down for %s with error: %v", test.host, h.Down, h.Duration, err)
+		}
+	}
+}
+
+// TestOutageStore tests the instances sharing the store
+// keep the outages of each other as they save theirs
+func TestOutageStore(t *testing.T) {
+	f := &fakeKV{}
+	ln := serveRedis(t, f.do)
+	defer ln.Close()
+
+	a := NewOutageStore(ln.Addr().String(), 0, "outages")
+	b := NewOutageStore(ln.Addr().String(), 0, "outages")
+	since := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	if _, err := a.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Save([]ack.Outage{{Host: "h1", Since: since}})
+	b.Save([]ack.Outage{{Host: "h2", Since: since}})
+	a.Save([]ack.Outage{{Host: "h1", Since: since, Ack: &ack.Ack{Author: "ops"}}, {Host: "h3", Since: since}})
+	b.Save(nil) // h2 up
+
+	list, err := NewOutageStore(ln.Addr().String(), 0, "outages").Load()
+	if err != nil || len(list) != 2 || list[0].Host != "h1" || list[0].Ack == nil || list[1].Host != "h3" || !list[1].Since.Equal(since) {
+		t.Errorf("Incorrect outages loaded: %+v with error: %v", list, err)
+	}
+}
+
+// TestNotifier tests only the DOWN and UP events are published and
+// saved, the escalations published without saving the status
+func TestNotifier(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	f := &fakeKV{keys: make(map[string]string)}
+	ln := serveRedis(t, f.do)
+	defer ln.Close()
+
+	notifyCh := make(chan pingd.HostStatus)
+	go NewNotifierFunc(ln.Addr().String(), 0, "up", "down")(notifyCh)
+	timeout := errors.New("timeout")
+	for _, h := range []pingd.HostStatus{
+		{Host: "h1", Down: true, Reason: timeout, Event: pingd.EventDown},
+		{Host: "h2", Down: true, Reason: timeout, Event: pingd.EventUnreachable},
+		{Host: "h1", Down: true, Reason: timeout, Event: pingd.EventReminder},
+		{Host: "h3", Down: true, Reason: timeout, Event: pingd.EventEscalation},
+		{Host: "h1", Down: false, Event: pingd.EventUp},
+	} {
+		notifyCh <- h
+	}
+
+	expected := []string{"down h1 timeout", "down h3 timeout", "up h1"}
+	deadline := time.Now().Add(time.Second)
+	for {
+		f.Lock()
+		published := append([]string(nil), f.published...)
+		_, h2 := f.keys[StatusPrefix+"h2"]
+		_, h3 := f.keys[StatusPrefix+"h3"]
+		h1 := f.keys[StatusPrefix+"h1"]
+		f.Unlock()
+		if len(published) >= len(expected) {
+			if fmt.Sprint(published) != fmt.Sprint(expected) || h1 != upStatus || h2 || h3 {
+				t.Errorf("Incorrect messages published: %q, or statuses saved h1: %s h2: %t h3: %t", published, h1, h2, h3)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Missing messages published: %q", published)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
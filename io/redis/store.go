@@ -0,0 +1,234 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/weaming/pingd/ack"
+	"github.com/weaming/pingd/escalation"
+	"github.com/weaming/pingd/silence"
+)
+
+// jsonHash keeps values as JSON in the fields of a redis hash, one per
+// host or rule, so that the instances sharing it only write the fields
+// they changed rather than overwriting those of the others
+type jsonHash struct {
+	redisAddr string
+	redisDB   int
+	key       string
+
+	mu    sync.Mutex
+	saved map[string]string // fields as last loaded or saved
+}
+
+func newJSONHash(redisAddr string, redisDB int, key string) jsonHash {
+	return jsonHash{redisAddr: redisAddr, redisDB: redisDB, key: key, saved: make(map[string]string)}
+}
+
+func (k *jsonHash) dial() (redis.Conn, error) {
+	return redis.Dial("tcp", k.redisAddr, redis.DialDatabase(k.redisDB))
+}
+
+// load returns the fields saved, none if the key doesn't exist
+func (k *jsonHash) load() (map[string]string, error) {
+	conn, err := k.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	fields, err := redis.StringMap(conn.Do("HGETALL", k.key))
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for f, v := range fields {
+		k.saved[f] = v
+	}
+	return fields, nil
+}
+
+// save sets the fields of values changed since last loaded or saved,
+// and deletes those no longer in values
+func (k *jsonHash) save(values map[string]interface{}) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	set := make(map[string]string)
+	for f, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if saved, ok := k.saved[f]; !ok || saved != string(data) {
+			set[f] = string(data)
+		}
+	}
+	var del []string
+	for f := range k.saved {
+		if _, ok := values[f]; !ok {
+			del = append(del, f)
+		}
+	}
+	if len(set) == 0 && len(del) == 0 {
+		return nil
+	}
+
+	conn, err := k.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for f, data := range set {
+		conn.Send("HSET", k.key, f, data)
+	}
+	for _, f := range del {
+		conn.Send("HDEL", k.key, f)
+	}
+	if _, err := conn.Do(""); err != nil {
+		return err
+	}
+	for f, data := range set {
+		k.saved[f] = data
+	}
+	for _, f := range del {
+		delete(k.saved, f)
+	}
+	return nil
+}
+
+// decode decodes the fields in order into the values new returns
+func decode(fields map[string]string, new func(field string) interface{}) error {
+	names := make([]string, 0, len(fields))
+	for f := range fields {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	for _, f := range names {
+		if v := new(f); v != nil {
+			if err := json.Unmarshal([]byte(fields[f]), v); err != nil {
+				return fmt.Errorf("%s %s: %s", f, fields[f], err)
+			}
+		}
+	}
+	return nil
+}
+
+// SilenceStore keeps the silences and the maintenance windows as JSON
+// in a redis hash, in the fields silence:<id> and window:<id>
+type SilenceStore struct {
+	jsonHash
+}
+
+// NewSilenceStore returns the store of the silences in key
+func NewSilenceStore(redisAddr string, redisDB int, key string) *SilenceStore {
+	return &SilenceStore{newJSONHash(redisAddr, redisDB, key)}
+}
+
+// Load returns the silences saved, none if the key doesn't exist
+func (s *SilenceStore) Load() (silence.Rules, error) {
+	var rules silence.Rules
+	fields, err := s.load()
+	if err != nil {
+		return rules, err
+	}
+	err = decode(fields, func(field string) interface{} {
+		switch {
+		case strings.HasPrefix(field, "silence:"):
+			rules.Silences = append(rules.Silences, &silence.Silence{})
+			return rules.Silences[len(rules.Silences)-1]
+		case strings.HasPrefix(field, "window:"):
+			rules.Windows = append(rules.Windows, &silence.Window{})
+			return rules.Windows[len(rules.Windows)-1]
+		}
+		return nil
+	})
+	return rules, err
+}
+
+// Save saves the silences
+func (s *SilenceStore) Save(rules silence.Rules) error {
+	values := make(map[string]interface{})
+	for _, r := range rules.Silences {
+		values["silence:"+r.ID] = r
+	}
+	for _, w := range rules.Windows {
+		values["window:"+w.ID] = w
+	}
+	return s.save(values)
+}
+
+// EscalationStore keeps the escalations in progress as JSON
+// in a redis hash, in a field per host
+type EscalationStore struct {
+	jsonHash
+}
+
+// NewEscalationStore returns the store of the escalations in key
+func NewEscalationStore(redisAddr string, redisDB int, key string) *EscalationStore {
+	return &EscalationStore{newJSONHash(redisAddr, redisDB, key)}
+}
+
+// Load returns the escalations saved, none if the key doesn't exist
+func (s *EscalationStore) Load() ([]escalation.Escalation, error) {
+	fields, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]escalation.Escalation, len(fields))
+	i := 0
+	err = decode(fields, func(string) interface{} {
+		i++
+		return &list[i-1]
+	})
+	return list, err
+}
+
+// Save saves the escalations
+func (s *EscalationStore) Save(list []escalation.Escalation) error {
+	values := make(map[string]interface{})
+	for _, e := range list {
+		values[e.Host] = e
+	}
+	return s.save(values)
+}
+
+// OutageStore keeps the outages and their acknowledgements as JSON
+// in a redis hash, in a field per host
+type OutageStore struct {
+	jsonHash
+}
+
+// NewOutageStore returns the store of the outages in key
+func NewOutageStore(redisAddr string, redisDB int, key string) *OutageStore {
+	return &OutageStore{newJSONHash(redisAddr, redisDB, key)}
+}
+
+// Load returns the outages saved, none if the key doesn't exist
+func (s *OutageStore) Load() ([]ack.Outage, error) {
+	fields, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]ack.Outage, len(fields))
+	i := 0
+	err = decode(fields, func(string) interface{} {
+		i++
+		return &list[i-1]
+	})
+	return list, err
+}
+
+// Save saves the outages
+func (s *OutageStore) Save(list []ack.Outage) error {
+	values := make(map[string]interface{})
+	for _, o := range list {
+		values[o.Host] = o
+	}
+	return s.save(values)
+}
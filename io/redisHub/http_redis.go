@@ -5,11 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 
 	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/ack"
+	"github.com/weaming/pingd/agent"
+	"github.com/weaming/pingd/heartbeat"
+	ioHTTP "github.com/weaming/pingd/io/http"
 	ioRedis "github.com/weaming/pingd/io/redis"
+	"github.com/weaming/pingd/silence"
 )
 
 type pingHTTP struct {
@@ -22,7 +28,31 @@ type pingHTTP struct {
 
 // ServeHTTP handles the incoming start/stop commands via HTTP
 func (p pingHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, heartbeat.Prefix) {
+		heartbeat.Handler.ServeHTTP(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, agent.Prefix) {
+		agent.Handler.ServeHTTP(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, ack.Prefix) {
+		ack.Handler.ServeHTTP(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, silence.Prefix) {
+		silence.Handler.ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path == ioHTTP.ResultPath {
+		ioHTTP.ResultHandler.ServeHTTP(w, r)
+		return
+	}
+
 	host := r.URL.Path[1:]
+	if r.URL.RawQuery != "" {
+		host += "?" + r.URL.RawQuery
+	}
 	if host == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(w, "missing host on request\n")
@@ -40,21 +70,24 @@ func (p pingHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "stop ping %s\n", host)
 		ioRedis.StopRedisHost(connKV, p.listKey, host, p.stopCh)
 	default:
-		_, hostname, _, err := ParseSchemeHostname(host)
-		if err != nil {
-			log.Println(err)
-		}
-		if strings.Contains(hostname, ":") {
-			hostname = strings.Split(hostname, ":")[0]
-		}
-
-		err = checkDNS(hostname)
+		target, err := pingd.ParseTarget(host)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			fmt.Fprint(w, err.Error())
 			return
 		}
 
+		// heartbeat hosts are tokens, not names to resolve, and
+		// the hosts of agents may only resolve in their networks
+		if hostname := target.URL.Hostname(); hostname != "" && net.ParseIP(hostname) == nil && target.URL.Scheme != "heartbeat" && target.Options.Get("agent") == "" {
+			err = checkDNS(hostname)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, err.Error())
+				return
+			}
+		}
+
 		fmt.Fprintf(w, "start ping %s\n", host)
 		ioRedis.StartRedisHost(connKV, p.listKey, host, p.startCh)
 	}
@@ -84,3 +117,12 @@ func NewReceiverFunc(listen string, redisAddr string, redisDB int, startKey, sto
 		}
 	}
 }
+
+// NewIngesterFunc returns the function that takes the results of external
+// probes, both POSTed to the webserver and published on the redis resultKey
+func NewIngesterFunc(redisAddr string, redisDB int, resultKey string) pingd.Ingester {
+	return func(resultCh chan<- pingd.Result) {
+		ioHTTP.NewIngesterFunc()(resultCh)
+		ioRedis.NewIngesterFunc(redisAddr, redisDB, resultKey)(resultCh)
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
+
+	"github.com/weaming/pingd/io/webhook"
 )
 
 const (
+	// API_URL is the default URL of the hub, see Hub
 	API_URL = "https://hub.drink.cafe/http"
 
 	TYPE_PLAIN    = "PLAIN"
@@ -20,6 +24,18 @@ const (
 	ACTION_SUB = "SUB"
 )
 
+// Hub is the webhook the messages are posted to, with its URLs,
+// signing key, retries... API_URL unless changed. It must be set
+// before the first message is posted.
+var Hub = webhook.Webhook{URLs: []string{API_URL}}
+
+// hub posts the messages to Hub from a queue, it's made
+// on the first message, with the client of all the others
+var hub struct {
+	once   sync.Once
+	sender *webhook.Sender
+}
+
 type PubMessage struct {
 	Action  string          `json:"action"`
 	Topics  []string        `json:"topics"`
@@ -52,9 +68,15 @@ func PostJson(api string, data interface{}) (map[string]interface{}, error) {
 	return result, nil
 }
 
+// PostToHub queues a message to be posted to the Hub without waiting,
+// failures are retried then logged as dead letters
 func PostToHub(data *PubMessage) error {
 	log.Printf("post to topics %v\n", data.Topics)
-	resp, err := PostJson(API_URL, data)
-	log.Println(resp)
-	return err
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	hub.once.Do(func() { hub.sender = webhook.NewSender(Hub) })
+	hub.sender.Send(body)
+	return nil
 }
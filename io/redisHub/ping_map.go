@@ -1,80 +1,99 @@
 package redisHub
 
 import (
-	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
-	"github.com/weaming/pingd/ping"
+	"github.com/weaming/pingd"
+	_ "github.com/weaming/pingd/dbping"   // redis and postgres checkers
+	_ "github.com/weaming/pingd/dnsping"  // dns checker
+	_ "github.com/weaming/pingd/grpcping" // grpc health checker
+	"github.com/weaming/pingd/httping"
+	_ "github.com/weaming/pingd/mailping" // smtp, imap and pop3 checkers
+	_ "github.com/weaming/pingd/ping"     // icmp checker
+	_ "github.com/weaming/pingd/tcping"   // tcp checker
+	_ "github.com/weaming/pingd/udping"   // udp checker
 )
 
-var httpGetClient = NewHTTPClient(10)
+// httpDefaults makes the http checks of PingMap GET the URL and
+// accept anything but server errors
+var httpDefaults = url.Values{"method": {"GET"}, "status": {"100-499"}}
 
+// withHTTPDefaults adds httpDefaults to the check options of http
+// hosts which don't set them, leaving the other hosts as they are
+func withHTTPDefaults(host string) string {
+	lower := strings.ToLower(host)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return host
+	}
+
+	target, fragment := host, ""
+	if i := strings.Index(host, "#"); i >= 0 {
+		target, fragment = host[:i], host[i+1:]
+	}
+	values, err := url.ParseQuery(fragment)
+	if err != nil {
+		return host
+	}
+	for k, v := range httpDefaults {
+		if _, ok := values[k]; !ok {
+			values[k] = v
+		}
+	}
+	return target + "#" + values.Encode()
+}
+
+// PingMap checks hosts with the checker registered for their scheme, see
+// pingd.RegisterChecker, the http ones with httpDefaults unless set
 type PingMap struct {
-	timeout   time.Duration
-	schemeMap map[string]func(string, time.Duration) (bool, error)
+	probe pingd.ProbeFunc
 }
 
 func NewPingMap(timeout time.Duration) PingMap {
-	return PingMap{
-		timeout,
-		map[string]func(string, time.Duration) (bool, error){
-			"http":  PingHTTP,
-			"https": PingHTTP,
-			"telnet": func(host string, timeout time.Duration) (bool, error) {
-				_, hostname, port, _ := ParseSchemeHostname(host)
-				err := rawConnect(hostname, port, timeout)
-				return err != nil, err
-			},
-		},
-	}
+	return PingMap{pingd.NewProbeFunc(timeout)}
 }
 
 func (p PingMap) Ping(host string) (up bool, err error) {
-	scheme, _, _, err := ParseSchemeHostname(host)
-	// log.Printf("ping %s with scheme %s\n", host, scheme)
-	if fn, ok := p.schemeMap[scheme]; ok {
-		return fn(host, p.timeout)
-	}
-	return ping.Ping(host)
+	probe := p.Probe(host)
+	return probe.Up, probe.Err
 }
 
+func (p PingMap) Probe(host string) pingd.Probe {
+	return p.probe(withHTTPDefaults(host))
+}
+
+// PingHTTP sends a GET request to the URL, the host is up unless it fails or answers
+// with a server error. Check options can be given in the URL fragment, see httping.ParseOptions.
 func PingHTTP(host string, timeout time.Duration) (up bool, err error) {
-	resp, err := NewHTTPClient(timeout).Get(host)
+	url, values, err := httping.SplitURL(host)
 	if err != nil {
 		return false, err
 	}
-	if resp.StatusCode < 500 {
-		return true, err
-	} else {
-		return false, fmt.Errorf("status code is %d", resp.StatusCode)
+	for k, v := range httpDefaults {
+		if _, ok := values[k]; !ok {
+			values[k] = v
+		}
 	}
-}
-
-func rawConnect(host string, port string, timeout time.Duration) error {
-	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), timeout)
+	o, err := httping.ParseOptions(values)
 	if err != nil {
-		// fmt.Println("Connecting error:", err)
-		return err
+		return false, err
 	}
-	if conn != nil {
-		defer conn.Close()
-		// fmt.Println("Opened", net.JoinHostPort(host, port))
+	if values.Get("timeout") == "" {
+		o.Timeout = timeout
 	}
-	return nil
+	return httping.PingWithOptions(url, o)
 }
 
 func ParseSchemeHostname(host string) (string, string, string, error) {
 	var u *url.URL
 	var err error
-	// treat google.com:443 style as telnet
+	// treat google.com:443 style as tcp
 	if !strings.Contains(host, "://") {
 		if strings.Contains(host, ":") {
-			fakeHost := "telnet://" + host
+			fakeHost := "tcp://" + host
 			u, err = url.Parse(fakeHost)
 		} else {
 			fakeHost := "icmp://" + host
@@ -90,16 +109,10 @@ func ParseSchemeHostname(host string) (string, string, string, error) {
 	return u.Scheme, u.Hostname(), u.Port(), nil
 }
 
+// NewHTTPClient returns a client with the default options and a timeout in seconds,
+// use httping.Options for TLS, auth, proxy and redirect settings
 func NewHTTPClient(timeout time.Duration) *http.Client {
-	// https://golang.org/src/net/http/transport.go
-
-	tr := &http.Transport{
-		MaxIdleConnsPerHost: 1000,
-		MaxIdleConns:        1000,
-		IdleConnTimeout:     60 * time.Second,
-	}
-	return &http.Client{
-		Transport: tr,
-		Timeout:   timeout * time.Second,
-	}
+	// options without files to load can not fail
+	client, _ := httping.Options{Timeout: timeout * time.Second}.NewClient()
+	return client
 }
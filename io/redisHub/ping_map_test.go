@@ -0,0 +1,23 @@
+package redisHub
+
+import "testing"
+
+func TestWithHTTPDefaults(t *testing.T) {
+	var hostsTT = []struct {
+		host     string
+		expected string
+	}{
+		{"http://example.org", "http://example.org#method=GET&status=100-499"},
+		{"HTTPS://example.org/a", "HTTPS://example.org/a#method=GET&status=100-499"},
+		{"https://example.org#status=200", "https://example.org#method=GET&status=200"},
+		{"https://example.org#method=HEAD&tags=web", "https://example.org#method=HEAD&status=100-499&tags=web"},
+		{"tcp://example.org:80", "tcp://example.org:80"},
+		{"example.org", "example.org"},
+	}
+
+	for _, tt := range hostsTT {
+		if host := withHTTPDefaults(tt.host); host != tt.expected {
+			t.Errorf("Incorrect options for host: %s resulted: %s expected: %s", tt.host, host, tt.expected)
+		}
+	}
+}
@@ -2,23 +2,23 @@
 package redisHub
 
 import (
-	"fmt"
 	"log"
 
 	"github.com/weaming/pingd"
 	"github.com/weaming/pingd/io/redis"
+	"github.com/weaming/pingd/template"
 )
 
 const (
-	upStatus   = "up"   // Status value for host up
-	downStatus = "down" // Status value for host down
-
 	// when receiving host on the start channel
 	// they can be requested to start as "down"
 	// adding it at the end eg "example.com down"
 	downSuffix = " down"
 )
 
+// Templates are the templates of the messages posted to the hub, hub
+var Templates = template.Default
+
 // NewNotifierFunc returns the function that
 // publishes on redis the up/down events
 func NewNotifierFunc(redisAddr string, redisDB int, upKey, downKey, topicPrefix string) pingd.Notifier {
@@ -31,27 +31,54 @@ func NewNotifierFunc(redisAddr string, redisDB int, upKey, downKey, topicPrefix
 			case h = <-notifyCh:
 				topics := []string{"global", topicPrefix, topicPrefix + "/" + h.Host}
 
+				// the statuses of the hosts of a digest are saved,
+				// the digest is posted in one message to their topics
+				if h.Event == pingd.EventDigest {
+					log.Println("DIGEST " + h.Host)
+					topics = topics[:2]
+					for _, g := range h.Group {
+						redis.SaveStatus(conn, g)
+						topics = append(topics, topicPrefix+"/"+g.Host)
+					}
+					conn.Send("BGSAVE")
+					conn.Flush()
+
+					PostToHub(NewPubMessage(TYPE_PLAIN, Templates.Render("hub", h), topics))
+					continue
+				}
+
+				// a flapping host state is saved, its transitions
+				// are published when it stops flapping
+				if h.Event == pingd.EventFlapping {
+					log.Println("FLAPPING " + h.Host)
+					redis.SaveStatus(conn, h)
+					conn.Flush()
+
+					PostToHub(NewPubMessage(TYPE_PLAIN, Templates.Render("hub", h), topics))
+					continue
+				}
+
 				switch h.Down {
 				// DOWN
 				case true:
 					log.Println("DOWN " + h.Host)
-					conn.Send("PUBLISH", downKey, fmt.Sprintf("%s %s", h.Host, h.Reason))
-					conn.Send("SET", redis.StatusPrefix+h.Host, downStatus)
+					conn.Send("PUBLISH", downKey, redis.Templates.Render("redis.down", h))
+					redis.SaveStatus(conn, h)
 					conn.Send("BGSAVE")
 					conn.Flush()
 
 					// hub
-					PostToHub(NewPubMessage(TYPE_PLAIN, fmt.Sprintf("DOWN %s: %s", h.Host, h.Reason), topics))
+					PostToHub(NewPubMessage(TYPE_PLAIN, Templates.Render("hub", h), topics))
 				// UP
 				case false:
 					log.Println("UP " + h.Host)
-					conn.Send("PUBLISH", upKey, h.Host)
-					conn.Send("SET", redis.StatusPrefix+h.Host, upStatus)
+					conn.Send("PUBLISH", upKey, redis.Templates.Render("redis.up", h))
+					redis.SaveStatus(conn, h)
 					conn.Send("BGSAVE")
 					conn.Flush()
 
 					// hub
-					PostToHub(NewPubMessage(TYPE_PLAIN, "UP "+h.Host, topics))
+					PostToHub(NewPubMessage(TYPE_PLAIN, Templates.Render("hub", h), topics))
 				}
 			}
 		}
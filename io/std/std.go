@@ -23,11 +23,13 @@ func NewNotifierFunc() pingd.Notifier {
 		for {
 			select {
 			case h := <-notifyCh:
-				switch h.Down {
-				case true:
-					log.Println("DOWN " + h.Host)
-				case false:
-					log.Println("UP " + h.Host)
+				switch h.Event {
+				case pingd.EventFlapping, pingd.EventFlappingEnd, pingd.EventReminder, pingd.EventEscalation, pingd.EventUnreachable:
+					log.Println(h.Event + " " + h.Host + " " + h.State())
+				case pingd.EventDigest:
+					log.Println(h.Event + " " + h.Host + " " + h.Message)
+				default:
+					log.Println(h.State() + " " + h.Host)
 				}
 			}
 		}
@@ -0,0 +1,333 @@
+// Package webhook posts the events to HTTP endpoints, as the JSON body
+// rendered from the webhook template, e.g.
+//
+//	pool.Notify = webhook.NewNotifierFunc(webhook.Webhook{
+//		URLs:   []string{"https://chat.example.org/hooks/ops"},
+//		Secret: os.Getenv("PINGD_WEBHOOK_SECRET"),
+//	})
+//
+// When Secret is set, the requests are signed with the HMAC-SHA256 of their
+// body in the X-Pingd-Signature header, e.g. sha256=5d5b09f6dcb2d53a5fff...
+// Failed requests, on network errors, 429 and 5xx responses, are retried
+// with exponential backoff, or after the Retry-After of the response. The
+// requests failing for good are dead letters, logged or kept with
+// DeadLetter. The notifier posts the events from a queue per URL, each in
+// a goroutine of its own, see NewSender, so a slow endpoint never holds
+// the events back, nor the other endpoints.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/template"
+)
+
+// SignatureHeader is the header of the signature of the requests
+const SignatureHeader = "X-Pingd-Signature"
+
+// DeadLetterFunc is a function which takes the requests failing for good
+type DeadLetterFunc func(url string, body []byte, err error)
+
+// ErrQueueFull is the error of the bodies dropped as the queue is full
+var ErrQueueFull = errors.New("webhook queue full")
+
+// Webhook is where and how the events are posted. Failed requests are
+// retried Retries times, 3 if not set and none if negative, waiting
+// Backoff, doubled on each retry, 1s if not set, or the Retry-After of
+// the response if any. Senders queue Queue bodies at most per URL, 100
+// if not set.
+type Webhook struct {
+	URLs       []string
+	Method     string      // POST if not set
+	Headers    http.Header // Content-Type is application/json if not set
+	Secret     string      // key signing the requests, if set
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+	Queue      int
+	Templates  *template.Templates // template.Default if not set
+	Template   string              // template of the body, webhook if not set
+	DeadLetter DeadLetterFunc      // logs the dead letters if not set
+	Client     *http.Client
+}
+
+// withDefaults returns the webhook with the defaults set
+func (w Webhook) withDefaults() Webhook {
+	if w.Method == "" {
+		w.Method = http.MethodPost
+	}
+	if w.Timeout == 0 {
+		w.Timeout = 10 * time.Second
+	}
+	if w.Retries == 0 {
+		w.Retries = 3
+	}
+	if w.Backoff == 0 {
+		w.Backoff = time.Second
+	}
+	if w.Queue == 0 {
+		w.Queue = 100
+	}
+	if w.Templates == nil {
+		w.Templates = template.Default
+	}
+	if w.Template == "" {
+		w.Template = "webhook"
+	}
+	if w.DeadLetter == nil {
+		w.DeadLetter = logDeadLetter
+	}
+	if w.Client == nil {
+		w.Client = &http.Client{Timeout: w.Timeout}
+	}
+	return w
+}
+
+// Sign returns the signature of a body with secret, as in SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify tells if the signature of a body is the one of secret
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// retryable is an error worth retrying the request for
+type retryable struct {
+	error
+	after time.Duration // Retry-After of the response, if any
+}
+
+// retryAfter returns the wait of a Retry-After header, in seconds
+// or as an HTTP date, 0 if not set or invalid
+func retryAfter(header string) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// do sends the body to url once
+func (w *Webhook) do(url string, body []byte) error {
+	req, err := http.NewRequest(w.Method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range w.Headers {
+		req.Header[name] = values
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return retryable{err, 0}
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return retryable{fmt.Errorf("%s answered %s", url, resp.Status), retryAfter(resp.Header.Get("Retry-After"))}
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+// send sends the body to url, retrying with backoff,
+// passing it to DeadLetter if it keeps failing
+func (w *Webhook) send(url string, body []byte) error {
+	backoff := w.Backoff
+	for retry := 0; ; retry++ {
+		err := w.do(url, body)
+		if err == nil {
+			return nil
+		}
+		r, ok := err.(retryable)
+		if !ok || retry >= w.Retries {
+			w.DeadLetter(url, body, err)
+			return err
+		}
+		wait := backoff
+		if r.after > 0 {
+			wait = r.after
+		}
+		log.Printf("ERROR posting to %s, retrying in %s: %s", url, wait, err)
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// Post sends the body to all the URLs, returning the first error.
+// It waits for the retries, see Sender to post without waiting.
+func (w *Webhook) Post(body []byte) error {
+	c := w.withDefaults()
+	return c.post(body)
+}
+
+// post sends the body to all the URLs of the webhook with the defaults set
+func (w *Webhook) post(body []byte) error {
+	errs := make([]error, len(w.URLs))
+	var wg sync.WaitGroup
+	for i, url := range w.URLs {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			errs[i] = w.send(url, body)
+		}(i, url)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sender posts bodies to a webhook from a queue per URL, each in a
+// goroutine of its own, for the callers not to wait for slow endpoints
+// and the retries, nor the endpoints for each other
+type Sender struct {
+	w      Webhook
+	queues []chan queued // queue of each URL
+}
+
+// queued is a body waiting to be posted, with the event it's about
+type queued struct {
+	body  []byte
+	event string
+}
+
+// NewSender returns the sender posting to the webhook, its client
+// and defaults being set once for all the bodies
+func NewSender(w Webhook) *Sender {
+	s := &Sender{w: w.withDefaults()}
+	s.queues = make([]chan queued, len(s.w.URLs))
+	for i, url := range s.w.URLs {
+		s.queues[i] = make(chan queued, s.w.Queue)
+		go s.run(url, s.queues[i])
+	}
+	return s
+}
+
+// run posts the bodies queued for url
+func (s *Sender) run(url string, queue <-chan queued) {
+	for q := range queue {
+		if err := s.w.send(url, q.body); err == nil && q.event != "" {
+			log.Println("WEBHOOK " + q.event + " to " + url)
+		}
+	}
+}
+
+// Send queues the body to be posted, the bodies overflowing
+// the queue being dead letters right away
+func (s *Sender) Send(body []byte) {
+	s.enqueue(body, "")
+}
+
+// enqueue queues the body of the event to be posted to each URL
+func (s *Sender) enqueue(body []byte, event string) {
+	for i, url := range s.w.URLs {
+		select {
+		case s.queues[i] <- queued{body, event}:
+		default:
+			s.w.DeadLetter(url, body, ErrQueueFull)
+		}
+	}
+}
+
+// Close stops the sender once the bodies queued are posted
+func (s *Sender) Close() {
+	for _, queue := range s.queues {
+		close(queue)
+	}
+}
+
+// NewNotifierFunc returns the function posting every event to the
+// webhook, failures are logged and never stop the notifier
+func NewNotifierFunc(w Webhook) pingd.Notifier {
+	w = w.withDefaults()
+	return func(notify <-chan pingd.HostStatus) {
+		s := NewSender(w)
+		defer s.Close()
+
+		for h := range notify {
+			body, err := w.Templates.Text(w.Template, h)
+			if err != nil {
+				log.Printf("ERROR rendering webhook body for %s: %s", h.Host, err)
+				continue
+			}
+			s.enqueue([]byte(body), h.Event+" "+h.Host)
+		}
+	}
+}
+
+func logDeadLetter(url string, body []byte, err error) {
+	log.Printf("DEAD LETTER to %s: %s, body: %s", url, err, body)
+}
+
+// deadLetter is a request failing for good, as written by FileDeadLetter
+type deadLetter struct {
+	Time  time.Time       `json:"time"`
+	URL   string          `json:"url"`
+	Error string          `json:"error"`
+	Body  json.RawMessage `json:"body"`
+}
+
+// FileDeadLetter returns the function appending the dead letters to
+// a file, as JSON lines, to be replayed by hand or by a script
+func FileDeadLetter(name string) DeadLetterFunc {
+	var mu sync.Mutex
+	return func(url string, body []byte, err error) {
+		logDeadLetter(url, body, err)
+
+		// bodies not JSON are kept as strings
+		raw := json.RawMessage(body)
+		if !json.Valid(body) {
+			raw, _ = json.Marshal(string(body))
+		}
+		line, _ := json.Marshal(deadLetter{time.Now(), url, err.Error(), raw})
+
+		mu.Lock()
+		defer mu.Unlock()
+		f, ferr := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if ferr != nil {
+			log.Println("ERROR writing dead letter:", ferr)
+			return
+		}
+		defer f.Close()
+		if _, ferr := f.Write(append(line, '\n')); ferr != nil {
+			log.Println("ERROR writing dead letter:", ferr)
+		}
+	}
+}
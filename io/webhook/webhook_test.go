@@ -0,0 +1,250 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// endpoint answers the requests with the codes given, then 200
+type endpoint struct {
+	mu       sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests = append(e.requests, r)
+	e.bodies = append(e.bodies, body)
+	if len(e.codes) > 0 {
+		w.WriteHeader(e.codes[0])
+		e.codes = e.codes[1:]
+	}
+}
+
+func (e *endpoint) reset(codes ...int) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.codes = codes
+	return len(e.requests)
+}
+
+func (e *endpoint) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.requests)
+}
+
+func TestPost(t *testing.T) {
+	e := &endpoint{}
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	var dead []string
+	w := Webhook{
+		URLs:       []string{ts.URL},
+		Method:     http.MethodPut,
+		Headers:    http.Header{"Authorization": {"Bearer token"}},
+		Secret:     "secret",
+		Retries:    2,
+		Backoff:    time.Millisecond,
+		DeadLetter: func(url string, body []byte, err error) { dead = append(dead, url) },
+	}
+
+	tests := []struct {
+		codes    []int
+		requests int
+		sent     bool
+	}{
+		{nil, 1, true},
+		{[]int{500, 502}, 3, true}, // retried until sent
+		{[]int{500, 502, 503}, 3, false},
+		{[]int{429}, 2, true},  // throttled, retried
+		{[]int{400}, 1, false}, // not retried
+	}
+	for _, test := range tests {
+		before := e.reset(test.codes...)
+		dead = nil
+		err := w.Post([]byte(`{"host": "h1"}`))
+		if requests := e.count() - before; requests != test.requests || (err == nil) != test.sent || (len(dead) == 0) != test.sent {
+			t.Errorf("Incorrect post answered %v resulted: %d requests, dead letters %v with error: %v", test.codes, requests, dead, err)
+		}
+	}
+
+	r := e.requests[0]
+	if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Incorrect request %s %v", r.Method, r.Header)
+	}
+	if sig := r.Header.Get(SignatureHeader); !Verify("secret", e.bodies[0], sig) || Verify("other", e.bodies[0], sig) {
+		t.Errorf("Incorrect signature %s", sig)
+	}
+
+	// network errors are retried
+	ts.Close()
+	dead = nil
+	if err := w.Post([]byte(`{}`)); err == nil || len(dead) != 1 {
+		t.Errorf("Expected dead letter posting to a closed server, got %v with error: %v", dead, err)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	e := &endpoint{}
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "dead.jsonl")
+
+	notify := make(chan pingd.HostStatus)
+	go NewNotifierFunc(Webhook{
+		URLs:       []string{ts.URL, ts.URL + "/second"},
+		Retries:    -1,
+		DeadLetter: FileDeadLetter(file),
+	})(notify)
+
+	notify <- pingd.HostStatus{Host: "db1#tag=db", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+	for start := time.Now(); e.count() < 2 && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var body map[string]interface{}
+	e.mu.Lock()
+	err = json.Unmarshal(e.bodies[0], &body)
+	e.mu.Unlock()
+	if err != nil || body["name"] != "db1" || body["state"] != "DOWN" || body["reason"] != "timeout" {
+		t.Errorf("Incorrect body %v with error: %v", body, err)
+	}
+
+	// a URL failing isn't retried, its event is a dead letter
+	e.reset(404)
+	notify <- pingd.HostStatus{Host: "db2", Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+	var data []byte
+	for start := time.Now(); len(data) == 0 && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+		data, _ = ioutil.ReadFile(file)
+	}
+	var letter deadLetter
+	if err := json.Unmarshal(data, &letter); err != nil || !strings.Contains(letter.Error, "404") || !strings.Contains(string(letter.Body), `"db2"`) {
+		t.Errorf("Incorrect dead letter %s with error: %v", data, err)
+	}
+}
+
+// TestSender tests a slow endpoint doesn't hold the bodies sent back,
+// nor the other endpoints, those overflowing its queue being dead letters
+func TestSender(t *testing.T) {
+	release := make(chan struct{})
+	e := &endpoint{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		e.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	fast := &endpoint{}
+	fastTS := httptest.NewServer(fast)
+	defer fastTS.Close()
+
+	var mu sync.Mutex
+	dropped := 0
+	s := NewSender(Webhook{
+		URLs:  []string{ts.URL, fastTS.URL},
+		Queue: 1,
+		DeadLetter: func(url string, body []byte, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == ErrQueueFull && url == ts.URL {
+				dropped++
+			}
+		},
+	})
+	defer s.Close()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		s.Send([]byte(`{}`))
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Bodies held back for %s", elapsed)
+	}
+
+	// one body is being posted, one waits, the others are dropped
+	mu.Lock()
+	if dropped < 3 {
+		t.Errorf("Got %d bodies dropped, expected at least 3", dropped)
+	}
+	mu.Unlock()
+
+	// the fast endpoint gets the bodies meanwhile, some may be
+	// dropped as the queue of 1 fills faster than it's posted
+	for start := time.Now(); fast.count() == 0 && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fast.count() == 0 {
+		t.Error("Got no bodies posted to the fast endpoint while the slow one holds")
+	}
+
+	close(release)
+	for start := time.Now(); e.count() < 5-dropped && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if posted := e.count(); posted != 5-dropped {
+		t.Errorf("Got %d bodies posted, expected %d", posted, 5-dropped)
+	}
+}
+
+// TestRetryAfter tests the throttled requests wait for the Retry-After
+// of the response rather than the backoff
+func TestRetryAfter(t *testing.T) {
+	e := &endpoint{}
+	throttled := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !throttled {
+			throttled = true
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		e.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	w := Webhook{URLs: []string{ts.URL}, Backoff: time.Millisecond}
+	start := time.Now()
+	if err := w.Post([]byte(`{}`)); err != nil || e.count() != 1 || time.Since(start) < time.Second {
+		t.Errorf("Incorrect post throttled for 1s resulted: %d requests in %s with error: %v", e.count(), time.Since(start), err)
+	}
+
+	tests := []struct {
+		header string
+		wait   time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"soon", 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), time.Hour},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, test := range tests {
+		if wait := retryAfter(test.header); wait > test.wait || wait < test.wait-2*time.Second {
+			t.Errorf("Incorrect wait for Retry-After: %q resulted: %s", test.header, wait)
+		}
+	}
+}
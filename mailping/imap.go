@@ -0,0 +1,71 @@
+package mailping
+
+import (
+	"fmt"
+	"strings"
+)
+
+// imapDialogue reads the server greeting, upgrades with STARTTLS and
+// logs in when requested, then logs out
+func imapDialogue(s *session) (string, error) {
+	greeting, err := s.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(greeting, "* OK"), strings.HasPrefix(greeting, "* PREAUTH"):
+	default:
+		return greeting, fmt.Errorf("greeting: %s", greeting)
+	}
+
+	tag := 0
+	if s.t.Flag("starttls") {
+		tag++
+		if err := imapCmd(s, tag, "STARTTLS"); err != nil {
+			return greeting, err
+		}
+		if err := s.startTLS(); err != nil {
+			return greeting, err
+		}
+	}
+
+	if user, password, ok := s.credentials(); ok {
+		tag++
+		if err := imapCmd(s, tag, "LOGIN %s %s", imapQuote(user), imapQuote(password)); err != nil {
+			return greeting, fmt.Errorf("LOGIN: %s", err)
+		}
+	}
+
+	tag++
+	imapCmd(s, tag, "LOGOUT")
+	return greeting, nil
+}
+
+// imapCmd sends a tagged command and waits for its OK completion,
+// untagged responses are skipped
+func imapCmd(s *session, tag int, format string, args ...interface{}) error {
+	id := fmt.Sprintf("a%d", tag)
+	if err := s.text.PrintfLine(id+" "+format, args...); err != nil {
+		return err
+	}
+	for {
+		line, err := s.text.ReadLine()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, id+" ") {
+			continue
+		}
+		status := strings.TrimPrefix(line, id+" ")
+		if !strings.HasPrefix(status, "OK") {
+			// NO or BAD
+			return fmt.Errorf("%s", status)
+		}
+		return nil
+	}
+}
+
+// imapQuote returns s as an IMAP quoted string
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
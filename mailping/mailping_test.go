@@ -0,0 +1,169 @@
+package mailping
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// serve runs a fake server answering each connection with dialogue
+func serve(t *testing.T, dialogue func(conn net.Conn, text *textproto.Conn)) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				dialogue(conn, textproto.NewConn(conn))
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestCheck(t *testing.T) {
+	// borrow a certificate for STARTTLS
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	starttls := func(conn net.Conn) *textproto.Conn {
+		tlsConn := tls.Server(conn, &tls.Config{Certificates: ts.TLS.Certificates})
+		return textproto.NewConn(tlsConn)
+	}
+
+	smtp := serve(t, func(conn net.Conn, text *textproto.Conn) {
+		text.PrintfLine("220 mail.example.org ESMTP ready")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.Fields(line); cmd[0] {
+			case "EHLO":
+				text.PrintfLine("250-mail.example.org\r\n250-STARTTLS\r\n250 AUTH LOGIN PLAIN")
+			case "STARTTLS":
+				text.PrintfLine("220 go ahead")
+				text = starttls(conn)
+			case "AUTH":
+				if b, _ := base64.StdEncoding.DecodeString(cmd[2]); string(b) == "\x00monitor\x00secret" {
+					text.PrintfLine("235 2.7.0 Authentication successful")
+				} else {
+					text.PrintfLine("535 5.7.8 Authentication credentials invalid")
+				}
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				return
+			}
+		}
+	})
+	defer smtp.Close()
+
+	smtpBusy := serve(t, func(conn net.Conn, text *textproto.Conn) {
+		text.PrintfLine("421 4.3.2 service not available")
+	})
+	defer smtpBusy.Close()
+
+	imap := serve(t, func(conn net.Conn, text *textproto.Conn) {
+		text.PrintfLine("* OK IMAP4rev1 ready")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.Fields(line)
+			switch cmd[1] {
+			case "STARTTLS":
+				text.PrintfLine("%s OK begin TLS", cmd[0])
+				text = starttls(conn)
+			case "LOGIN":
+				if cmd[2] == `"monitor"` && cmd[3] == `"secret"` {
+					text.PrintfLine("* CAPABILITY IMAP4rev1")
+					text.PrintfLine("%s OK logged in", cmd[0])
+				} else {
+					text.PrintfLine("%s NO [AUTHENTICATIONFAILED] invalid credentials", cmd[0])
+				}
+			case "LOGOUT":
+				text.PrintfLine("* BYE")
+				text.PrintfLine("%s OK logout", cmd[0])
+				return
+			default:
+				text.PrintfLine("%s BAD unknown command", cmd[0])
+			}
+		}
+	})
+	defer imap.Close()
+
+	pop3 := serve(t, func(conn net.Conn, text *textproto.Conn) {
+		text.PrintfLine("+OK POP3 ready")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.Fields(line); cmd[0] {
+			case "STLS":
+				text.PrintfLine("-ERR not supported")
+			case "USER":
+				text.PrintfLine("+OK")
+			case "PASS":
+				if cmd[1] == "secret" {
+					text.PrintfLine("+OK logged in")
+				} else {
+					text.PrintfLine("-ERR [AUTH] invalid credentials")
+				}
+			case "QUIT":
+				text.PrintfLine("+OK bye")
+				return
+			}
+		}
+	})
+	defer pop3.Close()
+
+	var checktests = []struct {
+		host    string
+		up      bool
+		message string
+	}{
+		{"smtp://" + smtp.Addr().String(), true, "mail.example.org ESMTP ready"},
+		{"smtp://" + smtp.Addr().String() + "#starttls&insecure", true, "mail.example.org ESMTP ready"},
+		{"smtp://" + smtp.Addr().String() + "#starttls", false, "mail.example.org ESMTP ready"},
+		{"smtp://monitor:secret@" + smtp.Addr().String() + "#starttls&insecure", true, "mail.example.org ESMTP ready"},
+		{"smtp://monitor:wrong@" + smtp.Addr().String() + "#starttls&insecure", false, "mail.example.org ESMTP ready"},
+		{"smtp://" + smtpBusy.Addr().String(), false, "4.3.2 service not available"},
+		{"imap://" + imap.Addr().String(), true, "* OK IMAP4rev1 ready"},
+		{"imap://monitor:secret@" + imap.Addr().String() + "#starttls&insecure", true, "* OK IMAP4rev1 ready"},
+		{"imap://" + imap.Addr().String() + "#user=monitor&password=wrong", false, "* OK IMAP4rev1 ready"},
+		{"pop3://monitor:secret@" + pop3.Addr().String(), true, "+OK POP3 ready"},
+		{"pop3://monitor:wrong@" + pop3.Addr().String(), false, "+OK POP3 ready"},
+		{"pop3://" + pop3.Addr().String() + "#starttls", false, "+OK POP3 ready"},
+	}
+
+	probe := pingd.NewProbeFunc(time.Second)
+	for _, tt := range checktests {
+		p := probe(tt.host)
+		if p.Up != tt.up || p.Message != tt.message {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", tt.host, p.Up, p.Message, p.Err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, host := range []string{"smtps://mail.example.org#starttls", "imap://mail.example.org#starttls&ca=/missing.pem"} {
+		if _, err := pingd.ParseTarget(host); err == nil {
+			t.Errorf("Expected error for host %s", host)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package mailping
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pop3Dialogue reads the server greeting, upgrades with STLS and
+// logs in when requested, then quits
+func pop3Dialogue(s *session) (string, error) {
+	greeting, err := pop3Reply(s)
+	if err != nil {
+		return greeting, fmt.Errorf("greeting: %s", err)
+	}
+
+	if s.t.Flag("starttls") {
+		if _, err := pop3Cmd(s, "STLS"); err != nil {
+			return greeting, fmt.Errorf("STLS: %s", err)
+		}
+		if err := s.startTLS(); err != nil {
+			return greeting, err
+		}
+	}
+
+	if user, password, ok := s.credentials(); ok {
+		if _, err := pop3Cmd(s, "USER %s", user); err != nil {
+			return greeting, fmt.Errorf("USER: %s", err)
+		}
+		if _, err := pop3Cmd(s, "PASS %s", password); err != nil {
+			return greeting, fmt.Errorf("PASS: %s", err)
+		}
+	}
+
+	pop3Cmd(s, "QUIT")
+	return greeting, nil
+}
+
+// pop3Cmd sends a command and reads its reply
+func pop3Cmd(s *session, format string, args ...interface{}) (string, error) {
+	if err := s.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return pop3Reply(s)
+}
+
+// pop3Reply reads a reply, failing unless it's +OK
+func pop3Reply(s *session) (string, error) {
+	line, err := s.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return line, fmt.Errorf("%s", line)
+	}
+	return line, nil
+}
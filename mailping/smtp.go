@@ -0,0 +1,90 @@
+package mailping
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// smtpDialogue greets the server, upgrades with STARTTLS and authenticates
+// with AUTH PLAIN when requested, then quits
+func smtpDialogue(s *session) (string, error) {
+	_, greeting, err := s.text.ReadResponse(220)
+	if err != nil {
+		return firstLine(greeting), fmt.Errorf("greeting: %s", err)
+	}
+	greeting = firstLine(greeting)
+
+	extensions, err := smtpHello(s)
+	if err != nil {
+		return greeting, err
+	}
+
+	if s.t.Flag("starttls") {
+		if _, ok := extensions["STARTTLS"]; !ok {
+			return greeting, fmt.Errorf("STARTTLS not offered")
+		}
+		if err := smtpCmd(s, 220, "STARTTLS"); err != nil {
+			return greeting, err
+		}
+		if err := s.startTLS(); err != nil {
+			return greeting, err
+		}
+		if extensions, err = smtpHello(s); err != nil {
+			return greeting, err
+		}
+	}
+
+	if user, password, ok := s.credentials(); ok {
+		if !strings.Contains(" "+extensions["AUTH"]+" ", " PLAIN ") {
+			return greeting, fmt.Errorf("AUTH PLAIN not offered")
+		}
+		auth := base64.StdEncoding.EncodeToString([]byte("\x00" + user + "\x00" + password))
+		if err := smtpCmd(s, 235, "AUTH PLAIN %s", auth); err != nil {
+			return greeting, fmt.Errorf("AUTH: %s", err)
+		}
+	}
+
+	smtpCmd(s, 221, "QUIT")
+	return greeting, nil
+}
+
+// smtpHello sends EHLO and returns the extensions offered by the server
+func smtpHello(s *session) (map[string]string, error) {
+	helo := s.t.Options.Get("helo")
+	if helo == "" {
+		helo = "localhost"
+	}
+	if err := s.text.PrintfLine("EHLO %s", helo); err != nil {
+		return nil, err
+	}
+	_, msg, err := s.text.ReadResponse(250)
+	if err != nil {
+		return nil, fmt.Errorf("EHLO: %s", err)
+	}
+
+	extensions := make(map[string]string)
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		args := strings.SplitN(line, " ", 2)
+		if len(args) > 1 {
+			extensions[strings.ToUpper(args[0])] = strings.ToUpper(args[1])
+		} else {
+			extensions[strings.ToUpper(args[0])] = ""
+		}
+	}
+	return extensions, nil
+}
+
+// smtpCmd sends a command and expects the given reply code
+func smtpCmd(s *session, code int, format string, args ...interface{}) error {
+	if err := s.text.PrintfLine(format, args...); err != nil {
+		return err
+	}
+	_, _, err := s.text.ReadResponse(code)
+	return err
+}
+
+// firstLine returns the first line of a multi-line reply
+func firstLine(msg string) string {
+	return strings.SplitN(msg, "\n", 2)[0]
+}
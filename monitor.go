@@ -1,6 +1,7 @@
 package pingd
 
 import (
+	"fmt"
 	"sync"
 	"time"
 )
@@ -11,42 +12,53 @@ type PingFunc func(host string) (up bool, err error)
 // Monitor is the main structure that represent a monitored host
 // Whenever a host goes up or down it notifies it on the corresponding channel
 type Monitor struct {
-	running   *sync.Mutex // monitor must run only once
-	lock      *sync.Mutex // protects internal values
-	ping      PingFunc
-	host      string
-	down      bool
-	failures  int
-	failLimit int
-	interval  time.Duration
-	stop      bool
-	notifyCh  chan<- HostStatus
+	running       *sync.Mutex // monitor must run only once
+	lock          *sync.Mutex // protects internal values
+	probe         ProbeFunc
+	host          string
+	down          bool
+	downSince     time.Time // when the host went down, as of its first failure
+	failedSince   time.Time // when the failures of the host up started
+	failures      int
+	failLimit     int
+	interval      time.Duration
+	stop          bool
+	flap          *flapping     // nil unless flap detection is enabled
+	reported      time.Time     // when the last result was reported, for passive monitors
+	resultTimeout time.Duration // passive monitors go down without results for longer
+	notifyCh      chan<- HostStatus
 }
 
-// NewMonitor takes a host, an initial state, and the notification channels and returns a monitorable host structure
-func NewMonitor(status HostStatus, ping PingFunc, notifyCh chan<- HostStatus) *Monitor {
+// NewMonitor takes a host, an initial state, and the notification channels and returns a monitorable host structure.
+// Hosts starting down have been down for the Duration of their status.
+func NewMonitor(status HostStatus, probe ProbeFunc, notifyCh chan<- HostStatus) *Monitor {
 	h := Monitor{
-		ping:     ping,
+		probe:    probe,
 		host:     status.Host,
 		down:     status.Down,
 		notifyCh: notifyCh,
 		running:  &sync.Mutex{},
 		lock:     &sync.Mutex{},
 	}
+	if h.down {
+		h.downSince = time.Now().Add(-status.Duration)
+	}
 
 	return &h
 }
 
-// Start begins the periodic pinging of the host
+// Start begins the periodic pinging of the host. Passive monitors
+// don't ping the host, they mark it down when no result is reported
+// for longer than their result timeout.
 func (m *Monitor) Start(interval time.Duration, failLimit int) {
 	m.running.Lock()
 	defer m.running.Unlock()
-	defer m.lock.Unlock()
 
 	m.lock.Lock()
 	m.interval = interval
 	m.failLimit = failLimit
 	m.stop = false
+	m.reported = time.Now()
 	m.lock.Unlock()
 
 	ticker := time.NewTicker(interval)
@@ -55,21 +67,83 @@ func (m *Monitor) Start(interval time.Duration, failLimit int) {
 	for _ = range ticker.C {
 		// log.Println("tick", m.host)
 		m.lock.Lock()
-		if m.stop {
+		stop := m.stop
+		m.lock.Unlock()
+		if stop {
 			return
 		}
-		m.lock.Unlock()
 
-		if up, err := m.ping(m.host); up {
+		if m.passive() {
+			if p, stale := m.stale(); stale {
+				m.markDown(p)
+			}
+			continue
+		}
+
+		if p := m.probe(m.host); p.Up {
 			// log.Println("pong " + m.host)
-			m.markUp()
+			m.markUp(p)
 		} else {
-			// log.Println("failed "+m.host, err)
-			m.markDown(err)
+			// log.Println("failed "+m.host, p.Err)
+			m.markDown(p)
 		}
 	}
 }
 
+// Report applies a probe result obtained outside the monitor,
+// as if the monitor had probed the host itself
+func (m *Monitor) Report(p Probe) {
+	m.lock.Lock()
+	if m.stop {
+		m.lock.Unlock()
+		return
+	}
+	m.reported = time.Now()
+	m.lock.Unlock()
+
+	if p.Up {
+		m.markUp(p)
+	} else {
+		m.markDown(p)
+	}
+}
+
+// detectFlapping enables flap detection, the host starts flapping when its
+// weighted percent of state changes reaches high and stops below low
+func (m *Monitor) detectFlapping(low, high float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.flap = &flapping{low: low, high: high}
+}
+
+// expectResults sets the time after which passive monitors
+// without reported results mark their host down
+func (m *Monitor) expectResults(timeout time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.resultTimeout = timeout
+}
+
+// stale tells if no result was reported for longer than the result
+// timeout, returning the failed probe marking the host down
+func (m *Monitor) stale() (Probe, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	since := time.Since(m.reported)
+	if m.resultTimeout <= 0 || since <= m.resultTimeout {
+		return Probe{}, false
+	}
+	return Probe{Err: fmt.Errorf("no result reported for %s", since.Round(time.Second))}, true
+}
+
+// passive tells if the monitor only gets reported results
+func (m *Monitor) passive() bool {
+	return m.probe == nil
+}
+
 // Stop stops pinging the host
 func (m *Monitor) Stop() {
 	m.lock.Lock()
@@ -79,39 +153,93 @@ func (m *Monitor) Stop() {
 }
 
 // markUp resets the failure count and the host status, then sends a channel notification that the host is up.
-func (m *Monitor) markUp() {
+func (m *Monitor) markUp(p Probe) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	h := status(m.host, p)
+	changed := false
 	if !m.down {
 		m.failures = 0
-		return
+	} else if m.failures--; m.failures <= 0 {
+		m.down = false
+		changed = true
 	}
-
-	m.failures--
-	if m.failures > 0 {
-		return
+	if m.down || changed {
+		h.Duration = time.Since(m.downSince)
 	}
+	h.Down = m.down
 
-	m.down = false
-	m.notifyCh <- HostStatus{Host: m.host, Down: m.down}
+	m.notify(h, false, changed)
 }
 
 // markDown does nothing if the host is already down. If it's up, in increases the failure count
 // changes the status to down and then sends a channel notification that the host is down.
-func (m *Monitor) markDown(err error) {
+// The host is down since its first failure, not since the fail limit was reached.
+func (m *Monitor) markDown(p Probe) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
+
+	changed := false
 	if m.down {
 		m.failures = m.failLimit
-		return
+	} else {
+		if m.failures == 0 {
+			m.failedSince = time.Now()
+		}
+		if m.failures++; m.failures >= m.failLimit {
+			m.down = true
+			m.downSince = m.failedSince
+			changed = true
+		}
+	}
+
+	h := status(m.host, p)
+	h.Down = m.down
+	if m.down {
+		h.Duration = time.Since(m.downSince)
+	}
+	h.Reason = p.Err
+	m.notify(h, true, changed)
+}
+
+// status returns the status of a host with the details of a probe
+func status(host string, p Probe) HostStatus {
+	return HostStatus{
+		Host:     host,
+		Latency:  p.Latency,
+		Message:  p.Message,
+		Degraded: p.Degraded,
+		Unknown:  p.Unknown,
+		Metrics:  p.Metrics,
 	}
+}
 
-	m.failures++
-	if m.failures < m.failLimit {
+// notify sends the status of the host when it changed, unless the host
+// is flapping, then only the start and the end of flapping are sent.
+// Flapping is told from the outcome of the checks, failed, rather than
+// from the state of the host, which doesn't change while the failures
+// stay below the fail limit. m.lock must be held.
+func (m *Monitor) notify(h HostStatus, failed, changed bool) {
+	if m.flap == nil {
+		if changed {
+			h.Event = h.State()
+			m.notifyCh <- h
+		}
 		return
 	}
 
-	m.down = true
-	m.notifyCh <- HostStatus{Host: m.host, Down: m.down, Reason: err}
+	started, stopped := m.flap.record(failed)
+	switch {
+	case started:
+		h.Event = EventFlapping
+		h.Message = fmt.Sprintf("%.1f%% state changes", m.flap.percent())
+	case stopped:
+		h.Event = EventFlappingEnd
+	case changed && !m.flap.on:
+		h.Event = h.State()
+	default:
+		return
+	}
+	m.notifyCh <- h
 }
@@ -0,0 +1,38 @@
+package pingd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestOutageDuration tests outages are timed from the first failed
+// check, and from the start saved for hosts starting down
+func TestOutageDuration(t *testing.T) {
+	notifyCh := make(chan HostStatus, 10)
+	m := NewMonitor(HostStatus{Host: "h1"}, nil, notifyCh)
+	m.failLimit = 3
+
+	down := Probe{Err: errors.New("timeout")}
+	m.Report(Probe{Up: true})
+	for i := 0; i < 3; i++ {
+		m.Report(down)
+		time.Sleep(20 * time.Millisecond)
+	}
+	if h := <-notifyCh; h.Event != EventDown || h.Duration < 40*time.Millisecond {
+		t.Errorf("Got %s event down for %s, expected DOWN since the first failure", h.Event, h.Duration)
+	}
+	for i := 0; i < 3; i++ {
+		m.Report(Probe{Up: true})
+	}
+	if h := <-notifyCh; h.Event != EventUp || h.Duration < 60*time.Millisecond {
+		t.Errorf("Got %s event down for %s, expected UP since the first failure", h.Event, h.Duration)
+	}
+
+	m = NewMonitor(HostStatus{Host: "h2", Down: true, Duration: time.Hour}, nil, notifyCh)
+	m.failLimit = 3
+	m.Report(Probe{Up: true})
+	if h := <-notifyCh; h.Event != EventUp || h.Duration < time.Hour {
+		t.Errorf("Got %s event down for %s, expected UP after the hour down before starting", h.Event, h.Duration)
+	}
+}
@@ -0,0 +1,18 @@
+package ping
+
+import (
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func init() {
+	pingd.RegisterChecker("icmp", pingd.CheckerFunc(check))
+}
+
+// check sends an ICMP echo to the host of an icmp:// target
+func check(t *pingd.Target) pingd.Probe {
+	start := time.Now()
+	up, err := PingTimeout(t.URL.Hostname(), t.Timeout)
+	return pingd.Probe{Up: up, Latency: time.Since(start), Err: err}
+}
@@ -18,6 +18,11 @@ var TimeOut = 3000 * time.Millisecond
 
 // Ping sends a ping command to a given host, returns whether is host answers or not
 func Ping(host string) (up bool, err error) {
+	return PingTimeout(host, TimeOut)
+}
+
+// PingTimeout is like Ping waiting up to timeout for the answer
+func PingTimeout(host string, timeout time.Duration) (up bool, err error) {
 
 	// Don't panic, just return nil
 	defer func() {
@@ -38,7 +43,7 @@ func Ping(host string) (up bool, err error) {
 		return false, err
 	}
 
-	c.SetDeadline(time.Now().Add(TimeOut))
+	c.SetDeadline(time.Now().Add(timeout))
 	defer c.Close()
 
 	xid, xseq := os.Getpid()&0xffff, 1
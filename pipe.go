@@ -0,0 +1,19 @@
+package pingd
+
+// Stage is a step of the notification pipeline, it reads the events from
+// in and passes on out those to notify, possibly delayed, changed or merged
+type Stage func(in <-chan HostStatus, out chan<- HostStatus)
+
+// Pipe returns a Notifier passing the events through the stages,
+// in order, before notifying them with n
+func Pipe(n Notifier, stages ...Stage) Notifier {
+	return func(notifyCh <-chan HostStatus) {
+		in := notifyCh
+		for _, stage := range stages {
+			out := make(chan HostStatus, 10)
+			go stage(in, out)
+			in = out
+		}
+		n(in)
+	}
+}
@@ -0,0 +1,40 @@
+package pingd
+
+import "testing"
+
+func TestPipe(t *testing.T) {
+	// drops the UP events
+	downOnly := func(in <-chan HostStatus, out chan<- HostStatus) {
+		for h := range in {
+			if h.Down {
+				out <- h
+			}
+		}
+		close(out)
+	}
+	// adds the tags to the message
+	tagged := func(in <-chan HostStatus, out chan<- HostStatus) {
+		for h := range in {
+			h.Message = h.Host + " " + Tags(h.Host)[0]
+			out <- h
+		}
+		close(out)
+	}
+
+	notifyCh := make(chan HostStatus, 3)
+	notifyCh <- HostStatus{Host: "h1#tag=db", Down: true}
+	notifyCh <- HostStatus{Host: "h2#tag=web", Down: false}
+	notifyCh <- HostStatus{Host: "h3#tag=web", Down: true}
+	close(notifyCh)
+
+	var messages []string
+	Pipe(func(in <-chan HostStatus) {
+		for h := range in {
+			messages = append(messages, h.Message)
+		}
+	}, downOnly, tagged)(notifyCh)
+
+	if len(messages) != 2 || messages[0] != "h1#tag=db db" || messages[1] != "h3#tag=web web" {
+		t.Errorf("Got messages %q, expected the DOWN events tagged", messages)
+	}
+}
@@ -8,11 +8,63 @@ import (
 // HostStatus is a wrap around a host (name or IP), the host status
 // represented by Down, and the reason why it's down. The status is
 // used as initial state when monitoring starts and a event
-// information when a host goes up or down.
+// information when a host goes up or down. Duration is how long the
+// host has been down, since its first failed check, in the events of
+// hosts down, UP events, and the statuses of hosts starting down, for
+// outages to be timed across restarts. DIGEST events carry the events
+// they merge in Group.
 type HostStatus struct {
-	Host   string `json:"host"`
-	Down   bool   `json:"down"`
-	Reason error  `json:"reason"`
+	Host     string        `json:"host"`
+	Down     bool          `json:"down"`
+	Reason   error         `json:"reason"`
+	Latency  time.Duration `json:"latency,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Message  string        `json:"message,omitempty"`
+	Degraded bool          `json:"degraded,omitempty"` // up, but with warnings
+	Unknown  bool          `json:"unknown,omitempty"`  // the check could not tell
+	Metrics  []Metric      `json:"metrics,omitempty"`  // measurements of the last check
+	Event    string        `json:"event,omitempty"`
+	Group    []HostStatus  `json:"group,omitempty"`
+}
+
+// Events sent by the monitors, FLAPPING_END carries the
+// state of the host after the transitions not sent
+const (
+	EventUp          = "UP"
+	EventDown        = "DOWN"
+	EventFlapping    = "FLAPPING"
+	EventFlappingEnd = "FLAPPING_END"
+
+	// UNREACHABLE hosts are down with all their parents, their
+	// DOWN event is sent as UNREACHABLE, then held until one of
+	// their parents is up
+	EventUnreachable = "UNREACHABLE"
+
+	// REMINDER events repeat the DOWN event of a host staying down
+	EventReminder = "REMINDER"
+
+	// ESCALATION events notify the outages not acknowledged to more people
+	EventEscalation = "ESCALATION"
+
+	// DIGEST events merge the events of many hosts, their Host
+	// is the name of the group
+	EventDigest = "DIGEST"
+)
+
+// State returns UP or DOWN, as the host is
+func (h HostStatus) State() string {
+	if h.Down {
+		return EventDown
+	}
+	return EventUp
+}
+
+// Events returns the events merged in a DIGEST event, or the event itself
+func (h HostStatus) Events() []HostStatus {
+	if h.Event == EventDigest {
+		return h.Group
+	}
+	return []HostStatus{h}
 }
 
 // Receiver is a functions which takes 2 channels of Host
@@ -32,14 +84,30 @@ type Loader func(chan<- HostStatus)
 
 // Pool is the structure that wraps the list of Host(s) that are
 // being monitored, with the monitoring parameters and the functions
-// interfacing with the rest of the system.
+// interfacing with the rest of the system. Probe, when set,
+// is used instead of Ping to get the latency and details of each check.
+// Ingest, when set, feeds the monitors with results probed outside.
+// The hosts only getting results go down when none comes for
+// ResultTimeout, 10 times the Interval if not set.
+// FlapHigh, when set, enables flap detection: hosts whose weighted
+// percent of state changes in the last 21 checks reaches FlapHigh
+// are flapping, and their transitions not notified, until it goes
+// below FlapLow, FlapHigh if not set. Nagios uses 20 and 30.
+// Hosts depending on others, given in their parent option, are
+// UNREACHABLE rather than DOWN when all their parents are down.
 type Pool struct {
 	Ping      PingFunc
+	Probe     ProbeFunc
 	Interval  time.Duration
 	FailLimit int
+	FlapLow   float64
+	FlapHigh  float64
 	Receive   Receiver
 	Notify    Notifier
 	Load      Loader
+	Ingest    Ingester
+
+	ResultTimeout time.Duration
 
 	list map[string]*Monitor
 }
@@ -51,6 +119,8 @@ func (p *Pool) Start() {
 	startHostCh := make(chan HostStatus, 10)
 	stopHostCh := make(chan HostStatus, 10)
 	notifyCh := make(chan HostStatus, 10)
+	resultCh := make(chan Result, 10)
+	eventCh := make(chan HostStatus, 10)
 
 	if p.Load != nil {
 		go p.Load(startHostCh)
@@ -64,27 +134,48 @@ func (p *Pool) Start() {
 		go p.Receive(startHostCh, stopHostCh)
 	}
 
-	go p.run(startHostCh, stopHostCh, notifyCh)
+	if p.Ingest != nil {
+		go p.Ingest(resultCh)
+	}
+
+	router := &router{
+		last:     make(map[string]HostStatus),
+		held:     make(map[string]bool),
+		waiting:  make(map[string]HostStatus),
+		wait:     2 * p.Interval,
+		recheck:  p.Interval * time.Duration(p.FailLimit+1),
+		notifyCh: notifyCh,
+	}
+	go router.route(eventCh)
+
+	go p.run(startHostCh, stopHostCh, resultCh, eventCh)
 }
 
 // run glues together the channels for communication with the host monitors
-// and the rest of the system.
-func (p *Pool) run(startHostCh, stopHostCh <-chan HostStatus, notifyCh chan<- HostStatus) {
+// and the rest of the system. The monitors events go through the router
+// evaluating the host dependencies before being notified.
+func (p *Pool) run(startHostCh, stopHostCh <-chan HostStatus, resultCh <-chan Result, eventCh chan<- HostStatus) {
 	for {
 		select {
 
 		// START
 		case h := <-startHostCh:
+			watch(h.Host, true)
 
-			if _, exists := p.list[h.Host]; exists {
+			// passive monitors are replaced by ones probing the host
+			if m, exists := p.list[h.Host]; exists && !m.passive() {
 				log.Println("RESTART pinging " + h.Host)
 				go func(h *Monitor) {
 					h.Stop()
 					h.Start(p.Interval, p.FailLimit)
 				}(p.list[h.Host])
 			} else {
+				if exists {
+					p.list[h.Host].Stop()
+				}
 				log.Println("NEW host " + h.Host)
-				p.list[h.Host] = NewMonitor(h, p.Ping, notifyCh)
+				p.list[h.Host] = p.newMonitor(h, p.probe(), eventCh)
+				eventCh <- HostStatus{Host: h.Host, Down: h.Down}
 				go func(h *Monitor) {
 					h.Start(p.Interval, p.FailLimit)
 				}(p.list[h.Host])
@@ -96,10 +187,55 @@ func (p *Pool) run(startHostCh, stopHostCh <-chan HostStatus, notifyCh chan<- Ho
 			if _, exists := p.list[h.Host]; exists {
 				log.Println("STOP pinging " + h.Host)
 				p.list[h.Host].Stop()
+				watch(h.Host, false)
+				eventCh <- HostStatus{Host: h.Host, Event: eventStopped}
 
 			} else {
 				log.Println("ERROR host not found " + h.Host)
 			}
+
+		// RESULT
+		case r := <-resultCh:
+
+			m, exists := p.list[r.Host]
+			if !exists {
+				log.Println("PASSIVE host " + r.Host)
+				m = p.newMonitor(HostStatus{Host: r.Host}, nil, eventCh)
+				m.failLimit = p.FailLimit
+				m.expectResults(p.resultTimeout())
+				p.list[r.Host] = m
+				go m.Start(p.Interval, p.FailLimit)
+			}
+			m.Report(r.Probe)
+		}
+	}
+}
+
+// probe returns the function checking the hosts
+func (p *Pool) probe() ProbeFunc {
+	if p.Probe != nil {
+		return p.Probe
+	}
+	return p.Ping.Probe
+}
+
+// resultTimeout returns the time passive monitors wait for results
+func (p *Pool) resultTimeout() time.Duration {
+	if p.ResultTimeout > 0 {
+		return p.ResultTimeout
+	}
+	return 10 * p.Interval
+}
+
+// newMonitor returns a monitor with the flap detection of the pool
+func (p *Pool) newMonitor(status HostStatus, probe ProbeFunc, notifyCh chan<- HostStatus) *Monitor {
+	m := NewMonitor(status, probe, notifyCh)
+	if p.FlapHigh > 0 {
+		low := p.FlapLow
+		if low <= 0 {
+			low = p.FlapHigh
 		}
+		m.detectFlapping(low, p.FlapHigh)
 	}
+	return m
 }
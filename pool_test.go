@@ -1,6 +1,7 @@
 package pingd
 
 import (
+	"errors"
 	"log"
 	"sync"
 	"testing"
@@ -20,12 +21,12 @@ func TestUpDownStartStop(t *testing.T) {
 	load := []string{"h1", "h2"}
 
 	resultSeq := []HostStatus{
-		HostStatus{"h2", true},  // h2 goes down first
-		HostStatus{"h1", true},  // h1 follows
-		HostStatus{"h2", false}, // h2 goes up
-		HostStatus{"h1", false}, // h1 goes up
-		HostStatus{"h1", true},  // h2 goes down
-		HostStatus{"h2", true},  // h1 goes down
+		HostStatus{Host: "h2", Down: true},  // h2 goes down first
+		HostStatus{Host: "h1", Down: true},  // h1 follows
+		HostStatus{Host: "h2", Down: false}, // h2 goes up
+		HostStatus{Host: "h1", Down: false}, // h1 goes up
+		HostStatus{Host: "h1", Down: true},  // h2 goes down
+		HostStatus{Host: "h2", Down: true},  // h1 goes down
 	}
 
 	startHostChFW, stopHostChFW, notifyChFW := createTestPool(seq, load)
@@ -38,8 +39,8 @@ func TestUpDownStartStop(t *testing.T) {
 		}
 	}
 
-	startHostChFW <- HostStatus{"h3", false} // start h3 as UP
-	startHostChFW <- HostStatus{"h4", true}  // start h4 as DOWN
+	startHostChFW <- HostStatus{Host: "h3", Down: false} // start h3 as UP
+	startHostChFW <- HostStatus{Host: "h4", Down: true}  // start h4 as DOWN
 
 	// Expect h4 to come UP (down=false) first
 	event := <-notifyChFW
@@ -139,7 +140,7 @@ func NewTestNotifyFunc(notifyFw chan<- HostStatus) Notifier {
 func NewLoaderFunc(hosts []string) Loader {
 	return func(load chan<- HostStatus) {
 		for _, host := range hosts {
-			load <- HostStatus{host, false}
+			load <- HostStatus{Host: host, Down: false}
 		}
 	}
 }
@@ -150,3 +151,98 @@ type SkipLog string
 func (s SkipLog) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
+
+// TestIngest tests results from outside feed the monitors, starting
+// passive ones for the hosts not monitored
+func TestIngest(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	notifyChFW := make(chan HostStatus)
+	resultChFW := make(chan Result)
+	var pool = &Pool{
+		Interval:      time.Millisecond,
+		FailLimit:     2,
+		Notify:        NewTestNotifyFunc(notifyChFW),
+		ResultTimeout: time.Hour,
+		Ingest: func(resultCh chan<- Result) {
+			for r := range resultChFW {
+				resultCh <- r
+			}
+		},
+	}
+	go pool.Start()
+
+	down := Probe{Err: errors.New("connection refused"), Message: "connection refused"}
+	up := Probe{Up: true, Degraded: true, Latency: 12 * time.Millisecond, Message: "ok", Metrics: []Metric{{Label: "load", Value: 1.5}}}
+	for _, r := range []Result{
+		{Host: "p1", Probe: down}, // below the fail limit
+		{Host: "p1", Probe: up},
+		{Host: "p1", Probe: down},
+		{Host: "p1", Probe: down}, // p1 goes down
+		{Host: "p1", Probe: up},
+		{Host: "p1", Probe: up}, // p1 goes up
+	} {
+		resultChFW <- r
+	}
+
+	event := <-notifyChFW
+	if event.Host != "p1" || !event.Down || event.Reason == nil || event.Reason.Error() != "connection refused" {
+		t.Errorf("Got event: %s %t %v, expected: p1 true connection refused", event.Host, event.Down, event.Reason)
+	}
+	event = <-notifyChFW
+	if event.Host != "p1" || event.Down || event.Latency != up.Latency || event.Message != "ok" {
+		t.Errorf("Got event: %s %t %s %q, expected: p1 false %s %q", event.Host, event.Down, event.Latency, event.Message, up.Latency, "ok")
+	}
+	if event.Duration <= 0 {
+		t.Errorf("Got duration %s of the outage, expected it measured", event.Duration)
+	}
+	if !event.Degraded || len(event.Metrics) != 1 {
+		t.Errorf("Got event: degraded %t metrics %v, expected the ones of the probe", event.Degraded, event.Metrics)
+	}
+
+	close(notifyChFW)
+	time.Sleep(time.Millisecond * 20)
+}
+
+// TestResultTimeout tests passive monitors mark their host
+// down when no result is reported in time
+func TestResultTimeout(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	notifyChFW := make(chan HostStatus)
+	resultChFW := make(chan Result)
+	var pool = &Pool{
+		Interval:      time.Millisecond,
+		FailLimit:     2,
+		Notify:        NewTestNotifyFunc(notifyChFW),
+		ResultTimeout: 20 * time.Millisecond,
+		Ingest: func(resultCh chan<- Result) {
+			for r := range resultChFW {
+				resultCh <- r
+			}
+		},
+	}
+	go pool.Start()
+
+	start := time.Now()
+	resultChFW <- Result{Host: "p1", Probe: Probe{Up: true}}
+
+	event := <-notifyChFW
+	if event.Host != "p1" || !event.Down || event.Reason == nil {
+		t.Errorf("Got event: %s %t %v, expected: p1 true no result reported", event.Host, event.Down, event.Reason)
+	}
+	if since := time.Since(start); since < pool.ResultTimeout {
+		t.Errorf("Got event after %s, expected it after the result timeout %s", since, pool.ResultTimeout)
+	}
+
+	resultChFW <- Result{Host: "p1", Probe: Probe{Up: true}}
+	resultChFW <- Result{Host: "p1", Probe: Probe{Up: true}}
+	event = <-notifyChFW
+	if event.Host != "p1" || event.Down {
+		t.Errorf("Got event: %s %t, expected: p1 false", event.Host, event.Down)
+	}
+
+	pool.list["p1"].Stop()
+}
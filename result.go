@@ -0,0 +1,92 @@
+package pingd
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Result is a probe outcome for a host obtained outside the pool,
+// e.g. by a script in a network segment pingd can't reach. It goes
+// through the same monitor, and notifications, as the pool probes.
+type Result struct {
+	Host  string
+	Probe Probe
+}
+
+// Ingester is a function which takes 1 channel of Result(s)
+// where it inserts the results submitted from outside. Results
+// for hosts not monitored start a passive monitor for them,
+// which doesn't probe the host itself.
+type Ingester func(chan<- Result)
+
+// resultMessage is the JSON form of a submitted result
+type resultMessage struct {
+	Host      string   `json:"host"`
+	Up        *bool    `json:"up"`
+	LatencyMS float64  `json:"latency_ms"`
+	Message   string   `json:"message"`
+	Reason    string   `json:"reason,omitempty"`
+	Degraded  bool     `json:"degraded,omitempty"`
+	Unknown   bool     `json:"unknown,omitempty"`
+	Metrics   []Metric `json:"metrics,omitempty"`
+}
+
+// MarshalJSON encodes the whole probe of the result in the format
+// of ParseResult, with the reason of a down host apart from its message
+func (r Result) MarshalJSON() ([]byte, error) {
+	up := r.Probe.Up
+	m := resultMessage{
+		Host:      r.Host,
+		Up:        &up,
+		LatencyMS: float64(r.Probe.Latency) / float64(time.Millisecond),
+		Message:   r.Probe.Message,
+		Degraded:  r.Probe.Degraded,
+		Unknown:   r.Probe.Unknown,
+		Metrics:   r.Probe.Metrics,
+	}
+	if r.Probe.Err != nil {
+		m.Reason = r.Probe.Err.Error()
+	}
+	return json.Marshal(m)
+}
+
+// ParseResult decodes a result submitted as JSON, e.g.
+//
+//	{"host": "db1.internal", "up": false, "latency_ms": 12.5, "message": "connection refused"}
+//
+// where the message of a down host is its reason, unless it has one.
+// Results can also tell the host is degraded or unknown, and carry
+// metrics, e.g. "metrics": [{"label": "load", "value": 1.5}]
+func ParseResult(data []byte) (Result, error) {
+	var m resultMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Result{}, err
+	}
+	if m.Host == "" {
+		return Result{}, errors.New("missing host")
+	}
+	if m.Up == nil {
+		return Result{}, errors.New("missing up")
+	}
+
+	p := Probe{
+		Up:       *m.Up,
+		Latency:  time.Duration(m.LatencyMS * float64(time.Millisecond)),
+		Message:  m.Message,
+		Degraded: m.Degraded,
+		Unknown:  m.Unknown,
+		Metrics:  m.Metrics,
+	}
+	if m.Reason != "" {
+		p.Err = errors.New(m.Reason)
+	}
+	if !p.Up && p.Err == nil {
+		reason := m.Message
+		if reason == "" {
+			reason = "reported down"
+		}
+		p.Err = errors.New(reason)
+	}
+	return Result{Host: m.Host, Probe: p}, nil
+}
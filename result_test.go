@@ -0,0 +1,73 @@
+package pingd
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseResult(t *testing.T) {
+	tests := []struct {
+		data    string
+		result  Result
+		invalid bool
+	}{
+		{`{"host": "db1", "up": true, "latency_ms": 12.5, "message": "ok"}`, Result{Host: "db1", Probe: Probe{Up: true, Latency: 12500 * time.Microsecond, Message: "ok"}}, false},
+		{`{"host": "db1", "up": false, "message": "connection refused"}`, Result{Host: "db1", Probe: Probe{Message: "connection refused"}}, false},
+		{`{"host": "db1", "up": false}`, Result{Host: "db1"}, false},
+		{`{"host": "db1"}`, Result{}, true},
+		{`{"up": true}`, Result{}, true},
+		{`db1 up`, Result{}, true},
+	}
+
+	for _, test := range tests {
+		r, err := ParseResult([]byte(test.data))
+		if test.invalid {
+			if err == nil {
+				t.Errorf("Expected error for %s", test.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.data, err)
+			continue
+		}
+		if r.Host != test.result.Host || r.Probe.Up != test.result.Probe.Up || r.Probe.Latency != test.result.Probe.Latency || r.Probe.Message != test.result.Probe.Message {
+			t.Errorf("Incorrect result for %s: %+v, expected: %+v", test.data, r, test.result)
+		}
+		if !r.Probe.Up && r.Probe.Err == nil {
+			t.Errorf("Missing reason for %s", test.data)
+		}
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	results := []Result{
+		{Host: "db1", Probe: Probe{Up: true, Latency: 12500 * time.Microsecond, Message: "ok"}},
+		{Host: "db1", Probe: Probe{Message: "503 Service Unavailable", Err: errors.New("status 503")}},
+		{Host: "exec:///usr/lib/nagios/plugins/check_load", Probe: Probe{Up: true, Degraded: true, Message: "WARNING - load 5.2",
+			Metrics: []Metric{{Label: "load1", Value: 5.2, Warn: "5", Crit: "10", Min: "0"}}}},
+		{Host: "db1", Probe: Probe{Unknown: true, Message: "plugin crashed", Err: errors.New("unknown (exit code 3)")}},
+	}
+
+	for _, r := range results {
+		data, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseResult(data)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", data, err)
+			continue
+		}
+		if parsed.Host != r.Host || parsed.Probe.Up != r.Probe.Up || parsed.Probe.Latency != r.Probe.Latency || parsed.Probe.Message != r.Probe.Message ||
+			parsed.Probe.Degraded != r.Probe.Degraded || parsed.Probe.Unknown != r.Probe.Unknown || !reflect.DeepEqual(parsed.Probe.Metrics, r.Probe.Metrics) {
+			t.Errorf("Incorrect result for %s: %+v, expected: %+v", data, parsed, r)
+		}
+		if !r.Probe.Up && (parsed.Probe.Err == nil || parsed.Probe.Err.Error() != r.Probe.Err.Error()) {
+			t.Errorf("Incorrect reason for %s: %v, expected: %v", data, parsed.Probe.Err, r.Probe.Err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package silence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron schedule: minute, hour, day of month, month and day of
+// week fields, each * or a list of values, ranges and steps, e.g. "0 2 * * 6,0"
+// for 2:00 on weekends or "*/30 9-17 * * 1-5". As cron, when both the day of
+// month and the day of week are restricted a day matching either matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // bit sets of the matching values
+	domStar, dowStar              bool
+}
+
+// fields are the ranges of the cron fields
+var fields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are Sunday
+}
+
+// ParseSchedule parses a cron schedule
+func ParseSchedule(spec string) (*Schedule, error) {
+	f := strings.Fields(spec)
+	if len(f) != len(fields) {
+		return nil, fmt.Errorf("invalid schedule %q, expected %d fields", spec, len(fields))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseField(f[i], field.min, field.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in schedule %q: %s", field.name, spec, err)
+		}
+		sets[i] = set
+	}
+
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Schedule{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: f[2] == "*",
+		dowStar: f[4] == "*",
+	}, nil
+}
+
+// parseField returns the bit set of the values of a field
+func parseField(s string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			if i := strings.Index(part, "-"); i >= 0 {
+				lo, err = strconv.Atoi(part[:i])
+				if err == nil {
+					hi, err = strconv.Atoi(part[i+1:])
+				}
+			} else {
+				lo, err = strconv.Atoi(part)
+				hi = lo
+				if step > 1 {
+					hi = max
+				}
+			}
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Match tells if the schedule matches the minute of t
+func (s *Schedule) Match(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Last returns the last time the schedule matched at or before t, looking
+// back as far as limit, and false if it didn't
+func (s *Schedule) Last(t time.Time, limit time.Duration) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for back := time.Duration(0); back <= limit; back += time.Minute {
+		if m := t.Add(-back); s.Match(m) {
+			return m, true
+		}
+	}
+	return time.Time{}, false
+}
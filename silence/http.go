@@ -0,0 +1,72 @@
+package silence
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Prefix is the path the HTTP receivers serve silences under
+const Prefix = "/silences/"
+
+// Handler lists, adds and removes the silences and the windows
+var Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, Prefix)
+
+	switch {
+	case r.Method == "GET" && path == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(List())
+
+	case r.Method == "POST" && path == "":
+		var s Silence
+		if !decode(w, r.Body, &s) {
+			return
+		}
+		id, err := Add(s)
+		reply(w, id, err)
+
+	case r.Method == "POST" && path == "windows/":
+		var win Window
+		if !decode(w, r.Body, &win) {
+			return
+		}
+		id, err := AddWindow(win)
+		reply(w, id, err)
+
+	case r.Method == "DELETE" && path != "":
+		if !Remove(path) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "no silence %s\n", path)
+			return
+		}
+		fmt.Fprintf(w, "silence %s removed\n", path)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+})
+
+// decode reads the JSON body of a request, answering 400 on error
+func decode(w http.ResponseWriter, body io.Reader, v interface{}) bool {
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(v); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return false
+	}
+	return true
+}
+
+// reply answers the ID of the silence or window added, or 400 on error
+func reply(w http.ResponseWriter, id string, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"id": id})
+}
@@ -0,0 +1,321 @@
+// Package silence holds the notifications of hosts during silences and
+// maintenance windows, while their probes go on.
+//
+// A silence holds the notifications of the hosts it matches from its start
+// to its end time, a maintenance window during each occurrence of its cron
+// schedule, for its duration. Both match hosts by glob, e.g. *.dc1.internal*,
+// or by tag, given in the tag options of the hosts, e.g. db1#tag=db. When
+// they end, hosts back up while silenced are notified UP, and, if NotifyEnd
+// is set, hosts still down are notified DOWN.
+//
+// They are kept in memory, and in the Store if set, and managed with the
+// HTTP receivers under Prefix:
+//
+//	GET    /silences/          list the silences and the windows, as JSON
+//	POST   /silences/          add a Silence, as JSON
+//	POST   /silences/windows/  add a Window, as JSON
+//	DELETE /silences/<id>      remove a silence or a window
+//
+// The notifiers hold the notifications with the stage of NewStage, e.g.
+//
+//	pool.Notify = pingd.Pipe(notifier, silence.NewStage())
+package silence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// Tick is how often the stage looks for ended silences and windows
+var Tick = 30 * time.Second
+
+// Matcher selects hosts by glob or by tag, see pingd.Matcher
+type Matcher = pingd.Matcher
+
+// Silence holds the notifications of the hosts it matches from Start to End
+type Silence struct {
+	ID string `json:"id"`
+	Matcher
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Author    string    `json:"author"`
+	Comment   string    `json:"comment,omitempty"`
+	NotifyEnd bool      `json:"notify_end,omitempty"` // notify hosts still down on end
+}
+
+// Active tells if the silence holds notifications at t
+func (s *Silence) Active(t time.Time) bool {
+	return !t.Before(s.Start) && t.Before(s.End)
+}
+
+// Window is a recurring maintenance window, holding the notifications
+// of the hosts it matches for Duration from each occurrence of Schedule,
+// in the time zone Location, the local one by default
+type Window struct {
+	ID string `json:"id"`
+	Matcher
+	Schedule  string   `json:"schedule"`
+	Duration  Duration `json:"duration"`
+	Location  string   `json:"location,omitempty"` // e.g. Europe/Paris
+	Author    string   `json:"author"`
+	Comment   string   `json:"comment,omitempty"`
+	NotifyEnd bool     `json:"notify_end,omitempty"` // notify hosts still down on end
+
+	schedule *Schedule
+	location *time.Location
+}
+
+// parse parses and validates the schedule and the location of the window
+func (w *Window) parse() error {
+	schedule, err := ParseSchedule(w.Schedule)
+	if err != nil {
+		return err
+	}
+	location, err := time.LoadLocation(w.Location)
+	if err != nil {
+		return err
+	}
+	if w.Duration <= 0 {
+		return errors.New("missing duration")
+	}
+	w.schedule, w.location = schedule, location
+	return nil
+}
+
+// Active tells if the window holds notifications at t
+func (w *Window) Active(t time.Time) bool {
+	start, ok := w.schedule.Last(t.In(w.location), time.Duration(w.Duration))
+	return ok && t.Before(start.Add(time.Duration(w.Duration)))
+}
+
+// Duration is a time.Duration given as a string in JSON, e.g. "2h30m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + time.Duration(d).String() + `"`), nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	if len(b) < 2 || b[0] != '"' || b[len(b)-1] != '"' {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	v, err := time.ParseDuration(string(b[1 : len(b)-1]))
+	*d = Duration(v)
+	return err
+}
+
+// Rules are the silences and the windows
+type Rules struct {
+	Silences []*Silence `json:"silences"`
+	Windows  []*Window  `json:"windows"`
+}
+
+// Store persists the rules
+type Store interface {
+	Load() (Rules, error)
+	Save(Rules) error
+}
+
+var rules = struct {
+	sync.Mutex
+	Rules
+	store Store
+}{}
+
+// SetStore loads the rules from s, and saves them there on each change
+func SetStore(s Store) error {
+	loaded, err := s.Load()
+	if err != nil {
+		return err
+	}
+	for _, w := range loaded.Windows {
+		if err := w.parse(); err != nil {
+			return fmt.Errorf("window %s: %s", w.ID, err)
+		}
+	}
+
+	rules.Lock()
+	defer rules.Unlock()
+	rules.Rules, rules.store = loaded, s
+	return nil
+}
+
+// save saves the rules in the store, if any, rules.Mutex must be held
+func save() {
+	if rules.store == nil {
+		return
+	}
+	if err := rules.store.Save(rules.Rules); err != nil {
+		log.Println("ERROR saving silences:", err)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Add adds a silence, returning its ID
+func Add(s Silence) (string, error) {
+	if err := s.Matcher.Validate(); err != nil {
+		return "", err
+	}
+	if s.Start.IsZero() {
+		s.Start = time.Now()
+	}
+	if !s.End.After(s.Start) {
+		return "", errors.New("end must be after start")
+	}
+	if s.Author == "" {
+		return "", errors.New("missing author")
+	}
+	s.ID = newID()
+
+	rules.Lock()
+	defer rules.Unlock()
+	rules.Silences = append(rules.Silences, &s)
+	save()
+	return s.ID, nil
+}
+
+// AddWindow adds a maintenance window, returning its ID
+func AddWindow(w Window) (string, error) {
+	if err := w.Matcher.Validate(); err != nil {
+		return "", err
+	}
+	if err := w.parse(); err != nil {
+		return "", err
+	}
+	if w.Author == "" {
+		return "", errors.New("missing author")
+	}
+	w.ID = newID()
+
+	rules.Lock()
+	defer rules.Unlock()
+	rules.Windows = append(rules.Windows, &w)
+	save()
+	return w.ID, nil
+}
+
+// Remove removes the silence or the window with the given ID
+func Remove(id string) bool {
+	rules.Lock()
+	defer rules.Unlock()
+
+	for i, s := range rules.Silences {
+		if s.ID == id {
+			rules.Silences = append(rules.Silences[:i:i], rules.Silences[i+1:]...)
+			save()
+			return true
+		}
+	}
+	for i, w := range rules.Windows {
+		if w.ID == id {
+			rules.Windows = append(rules.Windows[:i:i], rules.Windows[i+1:]...)
+			save()
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the silences and the windows, dropping the
+// silences ended, which don't hold notifications anymore
+func List() Rules {
+	rules.Lock()
+	defer rules.Unlock()
+
+	now := time.Now()
+	silences := rules.Silences[:0:0]
+	for _, s := range rules.Silences {
+		if now.Before(s.End) {
+			silences = append(silences, s)
+		}
+	}
+	if len(silences) != len(rules.Silences) {
+		rules.Silences = silences
+		save()
+	}
+	return Rules{
+		Silences: append([]*Silence(nil), rules.Silences...),
+		Windows:  append([]*Window(nil), rules.Windows...),
+	}
+}
+
+// Silenced tells if the notifications of host are held at t, and if
+// it must be notified still down when they end
+func Silenced(host string, t time.Time) (silenced, notifyEnd bool) {
+	rules.Lock()
+	defer rules.Unlock()
+
+	for _, s := range rules.Silences {
+		if s.Active(t) && s.Match(host) {
+			silenced = true
+			notifyEnd = notifyEnd || s.NotifyEnd
+		}
+	}
+	for _, w := range rules.Windows {
+		if w.Match(host) && w.Active(t) {
+			silenced = true
+			notifyEnd = notifyEnd || w.NotifyEnd
+		}
+	}
+	return silenced, notifyEnd
+}
+
+// held is the last event held of a host
+type held struct {
+	event     pingd.HostStatus
+	notifyEnd bool
+}
+
+// NewStage returns the stage holding the events of the silenced hosts,
+// passing their last one when the silences end: always when the host is
+// back up, when it's still down only if NotifyEnd was set
+func NewStage() pingd.Stage {
+	return func(in <-chan pingd.HostStatus, out chan<- pingd.HostStatus) {
+		holding := make(map[string]held)
+		notified := make(map[string]bool) // last state notified of the hosts
+
+		ticker := time.NewTicker(Tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case h := <-in:
+				if silenced, notifyEnd := Silenced(h.Host, time.Now()); silenced {
+					log.Println("SILENCED " + h.State() + " " + h.Host)
+					holding[h.Host] = held{h, notifyEnd || holding[h.Host].notifyEnd}
+					continue
+				}
+				delete(holding, h.Host)
+				notified[h.Host] = h.Down
+				out <- h
+
+			case now := <-ticker.C:
+				for host, hd := range holding {
+					if silenced, _ := Silenced(host, now); silenced {
+						continue
+					}
+					delete(holding, host)
+
+					h := hd.event
+					if h.Down == notified[host] || (h.Down && !hd.notifyEnd) {
+						continue
+					}
+					notified[host] = h.Down
+					out <- h
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package silence
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func TestSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		spec  string
+		time  string
+		match bool
+	}{
+		{"* * * * *", "2019-11-04 10:31", true},
+		{"0 2 * * 6,0", "2019-11-02 02:00", true},  // Saturday
+		{"0 2 * * 6,0", "2019-11-03 02:00", true},  // Sunday
+		{"0 2 * * 6,7", "2019-11-03 02:00", true},  // Sunday as 7
+		{"0 2 * * 6,0", "2019-11-04 02:00", false}, // Monday
+		{"0 2 * * 6,0", "2019-11-02 02:01", false},
+		{"*/30 9-17 * * 1-5", "2019-11-04 17:30", true},
+		{"*/30 9-17 * * 1-5", "2019-11-04 17:45", false},
+		{"*/30 9-17 * * 1-5", "2019-11-04 18:00", false},
+		{"15/20 * * * *", "2019-11-04 18:35", true},
+		{"0 0 1 * 1", "2019-11-01 00:00", true}, // the 1st, or a Monday
+		{"0 0 1 * 1", "2019-11-04 00:00", true},
+		{"0 0 1 * 1", "2019-11-05 00:00", false},
+		{"0 0 * 12 *", "2019-11-05 00:00", false},
+	}
+
+	for _, test := range tests {
+		s, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.spec, err)
+			continue
+		}
+		if s.Match(at(test.time)) != test.match {
+			t.Errorf("Incorrect match of %q at %s, expected %t", test.spec, test.time, test.match)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := &Window{Matcher: Matcher{Tag: "db"}, Schedule: "0 2 * * 0", Duration: Duration(2 * time.Hour), Location: "Europe/Paris"}
+	if err := w.parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sunday 2019-11-03 02:00 in Paris is 01:00 UTC
+	tests := []struct {
+		time   string
+		active bool
+	}{
+		{"2019-11-03T00:59:00Z", false},
+		{"2019-11-03T01:00:00Z", true},
+		{"2019-11-03T02:59:59Z", true},
+		{"2019-11-03T03:00:00Z", false},
+	}
+	for _, test := range tests {
+		tm, _ := time.Parse(time.RFC3339, test.time)
+		if w.Active(tm) != test.active {
+			t.Errorf("Incorrect window at %s, expected active %t", test.time, test.active)
+		}
+	}
+
+	if !w.Match("db1.internal#tag=prod&tag=db") || w.Match("web1.internal#tag=prod") {
+		t.Error("Incorrect window tag match")
+	}
+}
+
+func TestStage(t *testing.T) {
+	defer func(tick time.Duration) { Tick = tick }(Tick)
+	Tick = 10 * time.Millisecond
+	defer func() { rules.Rules = Rules{} }()
+
+	now := time.Now()
+	for _, s := range []Silence{
+		{Matcher: Matcher{Host: "*.dc1.internal"}, End: now.Add(100 * time.Millisecond), Author: "ops", NotifyEnd: true},
+		{Matcher: Matcher{Tag: "web"}, End: now.Add(100 * time.Millisecond), Author: "ops"},
+	} {
+		if _, err := Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	in := make(chan pingd.HostStatus)
+	out := make(chan pingd.HostStatus, 10)
+	go NewStage()(in, out)
+
+	down := func(host string) pingd.HostStatus {
+		return pingd.HostStatus{Host: host, Down: true, Reason: errors.New("timeout"), Event: pingd.EventDown}
+	}
+	in <- down("db1.dc1.internal")      // held, notified on end
+	in <- down("web1.internal#tag=web") // held, not notified on end
+	in <- down("db1.dc2.internal")      // not silenced
+
+	expected := []string{"db1.dc2.internal", "db1.dc1.internal"}
+	for _, host := range expected {
+		select {
+		case h := <-out:
+			if h.Host != host || !h.Down {
+				t.Errorf("Got event %s %t, expected %s true", h.Host, h.Down, host)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Missing event of %s", host)
+		}
+	}
+	select {
+	case h := <-out:
+		t.Errorf("Unexpected event %s %t", h.Host, h.Down)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHandler(t *testing.T) {
+	defer func() { rules.Rules = Rules{} }()
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		Handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := request("POST", "/silences/", `{"host": "*.dc1.internal", "end": "2099-01-01T00:00:00Z", "author": "ops", "comment": "move"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Adding silence got %d: %s", w.Code, w.Body)
+	}
+	w = request("POST", "/silences/windows/", `{"tag": "db", "schedule": "0 2 * * 0", "duration": "2h", "location": "UTC", "author": "ops"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Adding window got %d: %s", w.Code, w.Body)
+	}
+	for _, body := range []string{`{"host": "*", "author": "ops"}`, `{"host": "[", "end": "2099-01-01T00:00:00Z", "author": "ops"}`, `{"end": "2099-01-01T00:00:00Z", "author": "ops"}`} {
+		if w := request("POST", "/silences/", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Adding silence %s got %d, expected 400", body, w.Code)
+		}
+	}
+
+	list := List()
+	if len(list.Silences) != 1 || len(list.Windows) != 1 || list.Windows[0].Duration != Duration(2*time.Hour) {
+		t.Fatalf("Incorrect list %+v", list)
+	}
+	if silenced, _ := Silenced("web1.dc1.internal", time.Now()); !silenced {
+		t.Error("Host not silenced")
+	}
+
+	if w := request("DELETE", "/silences/"+list.Silences[0].ID, ""); w.Code != http.StatusOK {
+		t.Errorf("Removing silence got %d", w.Code)
+	}
+	if w := request("DELETE", "/silences/"+list.Silences[0].ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Removing silence again got %d", w.Code)
+	}
+	if silenced, _ := Silenced("web1.dc1.internal", time.Now()); silenced {
+		t.Error("Host still silenced")
+	}
+}
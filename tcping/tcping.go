@@ -0,0 +1,136 @@
+// Package tcping checks TCP services, optionally sending a payload and
+// matching the answer, e.g. the SSH banner or the SMTP greeting.
+//
+// Importing it registers the tcp:// scheme, which also checks hosts given
+// as host:port. Check options, in the URL fragment:
+//
+//	send=QUIT\r\n    payload sent once connected, \r \n \t and \\ are unescaped
+//	expect=220       substring the answer must contain
+//	match=^SSH-2\.0  regular expression the answer must match
+//	tls              connect with TLS, see pingd.Target.TLSConfig for its options
+package tcping
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// maxRead limits how much of the answer is read looking for a match
+const maxRead = 64 * 1024
+
+func init() {
+	pingd.RegisterChecker("tcp", checker{})
+	// telnet://host:port is how hosts were given before tcp://
+	pingd.RegisterChecker("telnet", checker{})
+}
+
+// checker connects to tcp targets
+type checker struct{}
+
+func (checker) Validate(t *pingd.Target) error {
+	if t.URL.Port() == "" {
+		return errors.New("missing port")
+	}
+	if _, err := regexp.Compile(t.Options.Get("match")); err != nil {
+		return err
+	}
+	if t.Flag("tls") {
+		if _, err := t.TLSConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (checker) Check(t *pingd.Target) pingd.Probe {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", t.URL.Host, t.Timeout)
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+	defer conn.Close()
+
+	connected := time.Since(start)
+	conn.SetDeadline(start.Add(t.Timeout))
+
+	if t.Flag("tls") {
+		config, err := t.TLSConfig()
+		if err != nil {
+			return pingd.Probe{Latency: connected, Err: err}
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return pingd.Probe{Latency: connected, Err: err}
+		}
+		conn = tlsConn
+	}
+
+	answer, err := Exchange(conn, Unescape(t.Options.Get("send")), t.Options.Get("expect"), t.Options.Get("match"))
+	if err != nil {
+		return pingd.Probe{Latency: connected, Message: answer, Err: err}
+	}
+	return pingd.Probe{Up: true, Latency: connected, Message: answer}
+}
+
+// Exchange writes send to conn, if not empty, then reads from it until the answer contains
+// expect and matches the regular expression match. With neither expect nor match it
+// doesn't read. The first line of the answer is returned.
+func Exchange(conn net.Conn, send, expect, match string) (string, error) {
+	if send != "" {
+		if _, err := conn.Write([]byte(send)); err != nil {
+			return "", err
+		}
+	}
+
+	if expect == "" && match == "" {
+		return "", nil
+	}
+
+	var re *regexp.Regexp
+	if match != "" {
+		var err error
+		if re, err = regexp.Compile(match); err != nil {
+			return "", err
+		}
+	}
+
+	var answer []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		answer = append(answer, buf[:n]...)
+
+		if (expect == "" || bytes.Contains(answer, []byte(expect))) && (re == nil || re.Match(answer)) {
+			return firstLine(answer), nil
+		}
+		if err != nil || len(answer) >= maxRead {
+			if len(answer) == 0 && err != nil {
+				return "", err
+			}
+			return firstLine(answer), fmt.Errorf("unexpected answer %q", firstLine(answer))
+		}
+	}
+}
+
+// firstLine returns the first line of b without the line ending
+func firstLine(b []byte) string {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimRight(string(b), "\r")
+}
+
+var unescaper = strings.NewReplacer(`\r`, "\r", `\n`, "\n", `\t`, "\t", `\\`, `\`)
+
+// Unescape replaces the \r, \n, \t and \\ escape sequences of a payload option
+func Unescape(s string) string {
+	return unescaper.Replace(s)
+}
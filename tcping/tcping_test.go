@@ -0,0 +1,69 @@
+package tcping
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func TestCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_8.0\r\n"))
+			conn.Close()
+		}
+	}()
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+
+	tls := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tls.Close()
+	tlsAddr := strings.TrimPrefix(tls.URL, "https://")
+
+	var checktests = []struct {
+		host    string
+		up      bool
+		message string
+	}{
+		{"tcp://" + ln.Addr().String(), true, ""},
+		{ln.Addr().String(), true, ""},
+		{"telnet://" + ln.Addr().String(), true, ""},
+		{"tcp://" + ln.Addr().String() + "#match=^SSH-2%5C.0", true, "SSH-2.0-OpenSSH_8.0"},
+		{"tcp://" + ln.Addr().String() + "#expect=OpenSSH", true, "SSH-2.0-OpenSSH_8.0"},
+		{"tcp://" + ln.Addr().String() + "#expect=220", false, "SSH-2.0-OpenSSH_8.0"},
+		{"tcp://" + closed.Addr().String(), false, ""},
+		{"tcp://" + tlsAddr + "#tls&insecure&send=HEAD / HTTP/1.0\\r\\n\\r\\n&expect=HTTP/1.0 200", true, "HTTP/1.0 200 OK"},
+		{"tcp://" + tlsAddr + "#tls&send=HEAD / HTTP/1.0\\r\\n\\r\\n&expect=HTTP/1.0 200", false, ""},
+	}
+
+	probe := pingd.NewProbeFunc(time.Second)
+	for _, tt := range checktests {
+		p := probe(tt.host)
+		if p.Up != tt.up || p.Message != tt.message {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", tt.host, p.Up, p.Message, p.Err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, host := range []string{"tcp://example.com", "tcp://example.com:22#match=(", "tcp://example.com:443#tls&cert=c.pem"} {
+		if _, err := pingd.ParseTarget(host); err == nil {
+			t.Errorf("Expected error for host %s", host)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package template
+
+// defaultText are the default text templates
+const defaultText = `
+{{- define "message" -}}
+{{- if eq .Event "FLAPPING" -}}
+host {{.Name}} is FLAPPING, notifications held until it settles
+{{- else if eq .Event "REMINDER" "ESCALATION" -}}
+host {{.Name}} is still DOWN, {{.Message}}
+{{- else if eq .Event "UNREACHABLE" -}}
+host {{.Name}} is UNREACHABLE, all its parents are down
+{{- else if eq .Event "FLAPPING_END" -}}
+host {{.Name}} stopped flapping, it is {{.State}}
+{{- else if eq .Event "DIGEST" -}}
+{{len .Hosts}} hosts of {{.Name}} changed, {{.Message}}
+{{- else -}}
+host {{.Name}} is {{.State}}{{if .Degraded}}, degraded{{end}}{{if .Unknown}}, check unknown{{end}}
+{{- end -}}
+{{- end -}}
+
+{{- define "subject" -}}
+{{template "message" .}}
+{{- end -}}
+
+{{- define "body" -}}
+{{template "message" .}}
+{{if .Hosts -}}
+{{range .Hosts}}
+{{.State}} {{.Name}}{{with .Reason}}: {{.}}{{end}}
+{{- end}}
+{{else -}}
+{{with .Reason}}
+Reason: {{.}}
+{{- end}}
+{{- with .Message}}
+Details: {{.}}
+{{- end}}
+{{- with .Tags}}
+Tags: {{join . ", "}}
+{{- end}}
+{{- with .Latency}}
+Latency: {{ms .}} ms
+{{- end}}
+{{- with .Duration}}
+Down for: {{duration .}}
+{{- end}}
+{{- with .Metrics}}
+Metrics:
+{{- range .}}
+  {{.Label}}: {{.Value}}{{.Unit}}
+{{- end}}
+{{- end}}
+{{end -}}
+{{- end -}}
+
+{{- define "redis.up" -}}
+{{.Host}}
+{{- end -}}
+
+{{- define "redis.down" -}}
+{{.Host}} {{.Reason}}
+{{- end -}}
+
+{{- define "hub" -}}
+{{- if eq .Event "FLAPPING" "DIGEST" -}}
+{{.Event}} {{.Name}}: {{.Message}}
+{{- else if .Down -}}
+DOWN {{.Name}}: {{.Reason}}
+{{- else -}}
+UP {{.Name}}
+{{- end -}}
+{{- end -}}
+
+{{- define "webhook.event" -}}
+{"host": {{json .Host}}, "name": {{json .Name}}, "state": {{json .State}}, "event": {{json .Event}}, "down": {{.Down}},
+{{- with .Reason}} "reason": {{json .Error}},{{end}}
+{{- with .Message}} "message": {{json .}},{{end}}
+{{- with .Tags}} "tags": {{json .}},{{end}}
+{{- with .Latency}} "latency_ms": {{ms .}},{{end}}
+{{- with .Duration}} "duration_s": {{.Seconds}},{{end}}
+{{- if .Degraded}} "degraded": true,{{end}}
+{{- if .Unknown}} "unknown": true,{{end}}
+{{- with .Metrics}} "metrics": {{json .}},{{end}} "text": {{json (render "message" .)}}
+{{- end -}}
+
+{{- define "webhook" -}}
+{{template "webhook.event" .}}
+{{- if .Hosts}}, "hosts": [{{range $i, $h := .Hosts}}{{if $i}}, {{end}}{{template "webhook.event" $h}}}{{end}}]{{end}}}
+{{- end -}}
+`
+
+// defaultHTML are the default HTML templates, they
+// can use the text templates, e.g. message
+const defaultHTML = `
+{{- define "html" -}}
+<html>
+<body>
+<p>{{template "message" .}}</p>
+{{- if .Hosts}}
+<table>
+{{- range .Hosts}}
+<tr><td>{{.State}}</td><td>{{.Name}}</td><td>{{with .Reason}}{{.}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<table>
+{{- with .Reason}}
+<tr><th>Reason</th><td>{{.}}</td></tr>
+{{- end}}
+{{- with .Message}}
+<tr><th>Details</th><td>{{.}}</td></tr>
+{{- end}}
+{{- with .Tags}}
+<tr><th>Tags</th><td>{{join . ", "}}</td></tr>
+{{- end}}
+{{- with .Latency}}
+<tr><th>Latency</th><td>{{ms .}} ms</td></tr>
+{{- end}}
+{{- with .Duration}}
+<tr><th>Down for</th><td>{{duration .}}</td></tr>
+{{- end}}
+{{- range .Metrics}}
+<tr><th>{{.Label}}</th><td>{{.Value}}{{.Unit}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+{{- end -}}
+`
@@ -0,0 +1,176 @@
+// Package template renders the messages of the notifiers from the events,
+// with text/template and html/template.
+//
+// The notifiers render the templates named after them from their
+// Templates, Default unless changed, e.g. the mail notifier renders
+// "message". Templates get the event as Data, e.g.
+//
+//	{{define "message"}}[ACME] {{.Name}} is {{.State}}{{with .Reason}}: {{.}}{{end}}{{end}}
+//
+// Parse and ParseFiles override the default templates defined in the text
+// given, leaving the others as they are:
+//
+//	mail.Templates, err = template.ParseFiles("alerts.tmpl", "alerts.html")
+//
+// The text templates are subject, message and body for the mail notifiers,
+// redis.up and redis.down for the redis ones, hub for the hub and webhook
+// for the JSON body of the webhooks, the HTML template is html for the
+// HTML body of the emails.
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	html "html/template"
+	"io/ioutil"
+	"log"
+	"strings"
+	text "text/template"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+// Data is what the templates get of an event: the event itself,
+// with Host, Reason, Latency, Duration, Event... the State method,
+// the name and the tags of the host, and the events merged by DIGEST
+// events as Hosts. Host and Name have the credentials of the host
+// redacted, see pingd.Redact and pingd.Name.
+type Data struct {
+	pingd.HostStatus
+	Host  string // host with its credentials redacted
+	Name  string // host without its check options nor its URL user info
+	Tags  []string
+	Hosts []Data
+}
+
+// NewData returns the data of an event
+func NewData(h pingd.HostStatus) Data {
+	d := Data{HostStatus: h, Host: pingd.Redact(h.Host), Name: pingd.Name(h.Host), Tags: pingd.Tags(h.Host)}
+	for _, g := range h.Group {
+		d.Hosts = append(d.Hosts, NewData(g))
+	}
+	return d
+}
+
+// funcs are the functions the templates can use
+var funcs = map[string]interface{}{
+	// duration rounds a duration to the second, e.g. 1h2m3s
+	"duration": func(d time.Duration) string { return d.Round(time.Second).String() },
+	// ms formats a latency in milliseconds, e.g. 12.3
+	"ms":   func(d time.Duration) string { return fmt.Sprintf("%.1f", float64(d)/float64(time.Millisecond)) },
+	"join": strings.Join,
+	// json encodes a value in JSON, e.g. a string for a JSON body
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Templates are the text and HTML templates of the messages
+type Templates struct {
+	text *text.Template
+	html *html.Template
+}
+
+// Default are the default templates
+var Default = MustParse("", "")
+
+// Parse returns the default templates, overridden by the ones defined
+// in the text templates textSrc and the HTML templates htmlSrc. The
+// HTML templates can use the text ones, e.g. message, and all can
+// render a text template to a string, e.g. {{json (render "message" .)}}.
+func Parse(textSrc, htmlSrc string) (*Templates, error) {
+	t := &Templates{}
+	// render renders a text template, e.g. to encode it in JSON
+	render := map[string]interface{}{
+		"render": func(name string, data interface{}) (string, error) {
+			var b bytes.Buffer
+			err := t.text.ExecuteTemplate(&b, name, data)
+			return b.String(), err
+		},
+	}
+
+	var err error
+	t.text = text.New("").Funcs(funcs).Funcs(render)
+	for _, src := range []string{defaultText, textSrc} {
+		if t.text, err = t.text.Parse(src); err != nil {
+			return nil, err
+		}
+	}
+
+	// the HTML templates can use the text ones, escaped
+	t.html = html.New("").Funcs(funcs).Funcs(render)
+	for _, src := range []string{defaultText, textSrc, defaultHTML, htmlSrc} {
+		if t.html, err = t.html.Parse(src); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// MustParse is Parse panicking on error
+func MustParse(textSrc, htmlSrc string) *Templates {
+	t, err := Parse(textSrc, htmlSrc)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseFiles is Parse with the templates in files, none if the name is empty
+func ParseFiles(textFile, htmlFile string) (*Templates, error) {
+	var src [2]string
+	for i, file := range []string{textFile, htmlFile} {
+		if file == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		src[i] = string(b)
+	}
+	return Parse(src[0], src[1])
+}
+
+// Text renders the text template name for the event
+func (t *Templates) Text(name string, h pingd.HostStatus) (string, error) {
+	var b bytes.Buffer
+	err := t.text.ExecuteTemplate(&b, name, NewData(h))
+	return b.String(), err
+}
+
+// HTML renders the HTML template name for the event
+func (t *Templates) HTML(name string, h pingd.HostStatus) (string, error) {
+	var b bytes.Buffer
+	err := t.html.ExecuteTemplate(&b, name, NewData(h))
+	return b.String(), err
+}
+
+// Render renders the text template name for the event, falling back
+// to the default one if it fails, so that events are always notified
+func (t *Templates) Render(name string, h pingd.HostStatus) string {
+	s, err := t.Text(name, h)
+	if err == nil || t == Default {
+		return s
+	}
+	log.Printf("ERROR rendering template %s for %s: %s", name, pingd.Redact(h.Host), err)
+	s, _ = Default.Text(name, h)
+	return s
+}
+
+// RenderHTML renders the HTML template name for the event,
+// falling back to the default one if it fails
+func (t *Templates) RenderHTML(name string, h pingd.HostStatus) string {
+	s, err := t.HTML(name, h)
+	if err == nil || t == Default {
+		return s
+	}
+	log.Printf("ERROR rendering template %s for %s: %s", name, pingd.Redact(h.Host), err)
+	s, _ = Default.HTML(name, h)
+	return s
+}
@@ -0,0 +1,106 @@
+package pingd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+// NewTLSConfig returns a TLS client configuration trusting the CAs in caFile
+// instead of the system roots, if set, and presenting the client certificate
+// in certFile and keyFile, if set
+func NewTLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("client certificate needs both cert and key")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
+
+// TLSOptions are the TLS options of the checks, see ParseTLSOptions
+type TLSOptions struct {
+	CAFile             string // PEM bundle trusted instead of the system roots
+	CertFile           string // PEM client certificate for mTLS
+	KeyFile            string // PEM key of the client certificate
+	InsecureSkipVerify bool
+	ServerName         string // server name to verify, the target host if not set
+}
+
+// ParseTLSOptions reads the TLS options of the check options
+//
+//	ca=          CA bundle file
+//	cert=, key=  client certificate and key files
+//	insecure     skip server certificate verification
+//	servername=  server name to verify, the target host by default
+func ParseTLSOptions(v url.Values) (TLSOptions, error) {
+	o := TLSOptions{
+		CAFile:             v.Get("ca"),
+		CertFile:           v.Get("cert"),
+		KeyFile:            v.Get("key"),
+		InsecureSkipVerify: flag(v, "insecure"),
+		ServerName:         v.Get("servername"),
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return o, fmt.Errorf("client certificate needs both cert and key")
+	}
+	return o, nil
+}
+
+// Config returns the TLS client configuration of the options
+func (o TLSOptions) Config() (*tls.Config, error) {
+	c, err := NewTLSConfig(o.CAFile, o.CertFile, o.KeyFile, o.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	c.ServerName = o.ServerName
+	return c, nil
+}
+
+// TLSConfig returns the TLS client configuration given by the check
+// options, see ParseTLSOptions
+func (t *Target) TLSConfig() (*tls.Config, error) {
+	o, err := ParseTLSOptions(t.Options)
+	if err != nil {
+		return nil, err
+	}
+	if o.ServerName == "" {
+		o.ServerName = t.URL.Hostname()
+	}
+	return o.Config()
+}
+
+// Flag reports whether a boolean check option is set,
+// given without value or with any value but false or 0
+func (t *Target) Flag(name string) bool {
+	return flag(t.Options, name)
+}
+
+func flag(options url.Values, name string) bool {
+	v, ok := options[name]
+	if !ok {
+		return false
+	}
+	return len(v) == 0 || (v[0] != "false" && v[0] != "0")
+}
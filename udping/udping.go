@@ -0,0 +1,139 @@
+// Package udping checks UDP services by sending them a datagram and
+// waiting for the reply.
+//
+// Importing it registers the udp:// scheme. A closed port answered with
+// ICMP port unreachable fails the check right away. Check options, in the URL fragment:
+//
+//	send=status\n    payload to send, \r \n \t and \\ are unescaped
+//	hex=0a1b2c       binary payload to send, in hexadecimal
+//	expect=OK        substring the reply must contain
+//	match=^OK        regular expression the reply must match
+//	noreply          don't expect a reply, e.g. for syslog, only the port unreachable fails
+//	wait=500ms       how long to wait for port unreachable with noreply, 1s by default
+package udping
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/tcping"
+)
+
+// Wait is how long a noreply check waits for a port unreachable
+var Wait = time.Second
+
+func init() {
+	pingd.RegisterChecker("udp", checker{})
+}
+
+// checker sends datagrams to udp targets
+type checker struct{}
+
+func (checker) Validate(t *pingd.Target) error {
+	if t.URL.Port() == "" {
+		return errors.New("missing port")
+	}
+	if _, err := payload(t); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(t.Options.Get("match")); err != nil {
+		return err
+	}
+	if s := t.Options.Get("wait"); s != "" {
+		if _, err := time.ParseDuration(s); err != nil {
+			return fmt.Errorf("invalid wait %q", s)
+		}
+	}
+	return nil
+}
+
+func (checker) Check(t *pingd.Target) pingd.Probe {
+	send, err := payload(t)
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+
+	conn, err := net.DialTimeout("udp", t.URL.Host, t.Timeout)
+	if err != nil {
+		return pingd.Probe{Err: err}
+	}
+	defer conn.Close()
+
+	noreply := t.Flag("noreply")
+	start := time.Now()
+	if noreply {
+		wait := Wait
+		if s := t.Options.Get("wait"); s != "" {
+			wait, _ = time.ParseDuration(s)
+		}
+		if wait > t.Timeout {
+			wait = t.Timeout
+		}
+		conn.SetDeadline(start.Add(wait))
+	} else {
+		conn.SetDeadline(start.Add(t.Timeout))
+	}
+
+	if _, err := conn.Write(send); err != nil {
+		return pingd.Probe{Err: err}
+	}
+
+	buf := make([]byte, 64*1024)
+	n, err := conn.Read(buf)
+	latency := time.Since(start)
+	if err != nil {
+		if e, ok := err.(net.Error); ok && e.Timeout() && noreply {
+			return pingd.Probe{Up: true, Message: "no reply"}
+		}
+		return pingd.Probe{Latency: latency, Err: err}
+	}
+
+	reply := buf[:n]
+	p := pingd.Probe{Latency: latency, Message: describe(reply)}
+
+	if expect := t.Options.Get("expect"); expect != "" && !bytes.Contains(reply, []byte(expect)) {
+		p.Err = fmt.Errorf("unexpected reply %q", p.Message)
+		return p
+	}
+	if match := t.Options.Get("match"); match != "" && !regexp.MustCompile(match).Match(reply) {
+		p.Err = fmt.Errorf("unexpected reply %q", p.Message)
+		return p
+	}
+
+	p.Up = true
+	return p
+}
+
+// payload returns the datagram to send to the target
+func payload(t *pingd.Target) ([]byte, error) {
+	if s := t.Options.Get("hex"); s != "" {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex payload: %s", err)
+		}
+		return b, nil
+	}
+	return []byte(tcping.Unescape(t.Options.Get("send"))), nil
+}
+
+// describe returns the first line of a text reply, or its size if binary
+func describe(reply []byte) string {
+	text := string(reply)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimRight(text, "\r")
+
+	if !utf8.ValidString(text) || strings.IndexFunc(text, func(r rune) bool { return r < ' ' && r != '\t' }) >= 0 {
+		return fmt.Sprintf("%d bytes", len(reply))
+	}
+	return text
+}
@@ -0,0 +1,72 @@
+package udping
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/weaming/pingd"
+)
+
+func TestCheck(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(append([]byte("echo "), buf[:n]...), addr)
+		}
+	}()
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	closed, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	closed.Close()
+
+	var checktests = []struct {
+		host    string
+		up      bool
+		message string
+	}{
+		{"udp://" + echo.LocalAddr().String() + "#send=hello", true, "echo hello"},
+		{"udp://" + echo.LocalAddr().String() + "#send=hello&expect=hello", true, "echo hello"},
+		{"udp://" + echo.LocalAddr().String() + "#send=hello&match=^echo h", true, "echo hello"},
+		{"udp://" + echo.LocalAddr().String() + "#send=hello&expect=bye", false, "echo hello"},
+		{"udp://" + echo.LocalAddr().String() + "#hex=0001", true, "7 bytes"},
+		{"udp://" + silent.LocalAddr().String() + "#send=hello&timeout=100ms", false, ""},
+		{"udp://" + silent.LocalAddr().String() + "#send=<14>test&noreply&wait=50ms", true, "no reply"},
+		{"udp://" + closed.LocalAddr().String() + "#send=hello", false, ""},
+		{"udp://" + closed.LocalAddr().String() + "#send=<14>test&noreply", false, ""},
+	}
+
+	probe := pingd.NewProbeFunc(time.Second)
+	for _, tt := range checktests {
+		start := time.Now()
+		p := probe(tt.host)
+		if p.Up != tt.up || p.Message != tt.message {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", tt.host, p.Up, p.Message, p.Err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("Slow check for host: %s took %s", tt.host, d)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, host := range []string{"udp://example.com", "udp://example.com:53#hex=xyz", "udp://example.com:53#match=(", "udp://example.com:514#wait=soon"} {
+		if _, err := pingd.ParseTarget(host); err == nil {
+			t.Errorf("Expected error for host %s", host)
+		}
+	}
+}
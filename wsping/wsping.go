@@ -0,0 +1,114 @@
+// Package wsping checks WebSocket endpoints complete the upgrade
+// handshake and, optionally, answer a message.
+//
+// Importing it registers the ws and wss schemes. Check options, in the URL fragment:
+//
+//	send=ping        text message sent once connected, \r \n \t and \\ are unescaped
+//	expect=pong      substring the reply must contain
+//	match=^pong$     regular expression the reply must match
+//	origin=          Origin header of the handshake
+//	bearer=          bearer token for the handshake
+//
+// plus the TLS options of pingd.Target.TLSConfig for wss. Without send, expect
+// or match only the handshake is checked, otherwise replies not matching are
+// skipped until one does or the timeout expires.
+package wsping
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/weaming/pingd"
+	"github.com/weaming/pingd/tcping"
+)
+
+func init() {
+	pingd.RegisterChecker("ws", checker{})
+	pingd.RegisterChecker("wss", checker{})
+}
+
+// checker connects to ws and wss targets
+type checker struct{}
+
+func (checker) Validate(t *pingd.Target) error {
+	if _, err := regexp.Compile(t.Options.Get("match")); err != nil {
+		return err
+	}
+	if t.URL.Scheme == "wss" {
+		if _, err := t.TLSConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (checker) Check(t *pingd.Target) pingd.Probe {
+	dialer := websocket.Dialer{HandshakeTimeout: t.Timeout, Proxy: http.ProxyFromEnvironment}
+	if t.URL.Scheme == "wss" {
+		config, err := t.TLSConfig()
+		if err != nil {
+			return pingd.Probe{Err: err}
+		}
+		dialer.TLSClientConfig = config
+	}
+
+	header := http.Header{}
+	if origin := t.Options.Get("origin"); origin != "" {
+		header.Set("Origin", origin)
+	}
+	if bearer := t.Options.Get("bearer"); bearer != "" {
+		header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	start := time.Now()
+	conn, resp, err := dialer.Dial(t.URL.String(), header)
+	latency := time.Since(start)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%s: %s", err, resp.Status)
+		}
+		return pingd.Probe{Latency: latency, Err: err}
+	}
+	defer conn.Close()
+
+	p := pingd.Probe{Latency: latency, Message: resp.Status}
+	send, expect, match := t.Options.Get("send"), t.Options.Get("expect"), t.Options.Get("match")
+	if send == "" && expect == "" && match == "" {
+		p.Up = true
+		return p
+	}
+
+	conn.SetWriteDeadline(start.Add(t.Timeout))
+	conn.SetReadDeadline(start.Add(t.Timeout))
+	if send != "" {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(tcping.Unescape(send))); err != nil {
+			p.Err = err
+			return p
+		}
+	}
+
+	var re *regexp.Regexp
+	if match != "" {
+		re = regexp.MustCompile(match)
+	}
+	for {
+		_, reply, err := conn.ReadMessage()
+		if err != nil {
+			p.Err = fmt.Errorf("no matching reply: %s", err)
+			return p
+		}
+		if (expect == "" || bytes.Contains(reply, []byte(expect))) && (re == nil || re.Match(reply)) {
+			p.Up = true
+			p.Latency = time.Since(start)
+			p.Message = strings.SplitN(string(reply), "\n", 2)[0]
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return p
+		}
+	}
+}
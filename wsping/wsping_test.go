@@ -0,0 +1,65 @@
+package wsping
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/weaming/pingd"
+)
+
+func TestCheck(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "no websocket here", http.StatusBadGateway)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte("welcome"))
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, append([]byte("echo: "), msg...))
+		}
+	})
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	tls := httptest.NewTLSServer(handler)
+	defer tls.Close()
+
+	ws := "ws" + strings.TrimPrefix(ts.URL, "http")
+	wss := "wss" + strings.TrimPrefix(tls.URL, "https")
+
+	var checktests = []struct {
+		host    string
+		up      bool
+		message string
+	}{
+		{ws + "/notify", true, "101 Switching Protocols"},
+		{ws + "/notify#expect=welcome", true, "welcome"},
+		{ws + "/notify#send=ping&match=^echo: ping$", true, "echo: ping"},
+		{ws + "/notify#send=ping&expect=pong&timeout=200ms", false, "101 Switching Protocols"},
+		{ws + "/broken", false, ""},
+		{wss + "/notify#insecure&send=ping&expect=ping", true, "echo: ping"},
+		{wss + "/notify", false, ""},
+	}
+
+	probe := pingd.NewProbeFunc(time.Second)
+	for _, tt := range checktests {
+		p := probe(tt.host)
+		if p.Up != tt.up || p.Message != tt.message {
+			t.Errorf("Incorrect check for host: %s resulted: %t %q with error: %v", tt.host, p.Up, p.Message, p.Err)
+		}
+	}
+}